@@ -14,6 +14,8 @@ const (
 	serverErrMessage          = ErrorMessage("Something unplanned for has gone wrong")
 	unauthErrMsg              = ErrorMessage("Authentication details were not provided")
 	authenticationErrMsg      = ErrorMessage("Authentication credentials are invalid")
+	conflictErrCode           = ErrorCode("conflict")
+	conflictErrMessage        = ErrorMessage("The resource was changed by another request, please retry with its latest version")
 )
 
 // ErrorParams represents the functionality of error parameters
@@ -65,6 +67,11 @@ func (p ErrorParams) ToServerError() Error {
 	return ServerError(p)
 }
 
+// ToConflict converts error params to a conflict error
+func (p ErrorParams) ToConflict() Error {
+	return ConflictError(p)
+}
+
 // BadRequestError creates a new bad request error
 func BadRequestError(p ErrorParams) Error {
 	return Error{
@@ -93,6 +100,15 @@ func ServerError(p ErrorParams) Error {
 	}
 }
 
+// ConflictError creates a new conflict error
+func ConflictError(p ErrorParams) Error {
+	return Error{
+		Code:    conflictErrCode,
+		Message: conflictErrMessage,
+		Params:  p,
+	}
+}
+
 // IsBadRequestError checks if an error is a bad request error
 func IsBadRequestError(err error) bool {
 	cause := pkgErr.Cause(err)
@@ -120,3 +136,10 @@ func IsServerError(err error) bool {
 	customErr, ok := cause.(Error)
 	return ok && customErr.Code == serverErrCode
 }
+
+// IsConflictError checks if an error is a conflict error
+func IsConflictError(err error) bool {
+	cause := pkgErr.Cause(err)
+	customErr, ok := cause.(Error)
+	return ok && customErr.Code == conflictErrCode
+}
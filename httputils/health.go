@@ -0,0 +1,76 @@
+package httputils
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	healthUp   = "up"
+	healthDown = "down"
+)
+
+// HealthChecker checks that a dependency of the service is healthy e.g mongo.HealthChecker
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// HealthStatus is a representation of the health of a dependency
+type HealthStatus struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latencyMs"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HealthzHandler serves the health of the dependencies, keyed by name, for liveness probes
+// It always responds with 200 as an unhealthy dependency is no reason to restart the service
+func HealthzHandler(checkers map[string]HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, _ := runHealthChecks(r.Context(), checkers)
+		ServeGeneralJSON(report, w, http.StatusOK)
+	}
+}
+
+// ReadyzHandler serves the health of the dependencies, keyed by name, for readiness probes
+// It responds with 503 when any dependency is unhealthy
+func ReadyzHandler(checkers map[string]HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, healthy := runHealthChecks(r.Context(), checkers)
+		if !healthy {
+			ServeJSON(GenericResponse{Status: failureMessage, Data: report}, w, http.StatusServiceUnavailable)
+			return
+		}
+		ServeGeneralJSON(report, w, http.StatusOK)
+	}
+}
+
+func runHealthChecks(ctx context.Context, checkers map[string]HealthChecker) (map[string]HealthStatus, bool) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		healthy = true
+		report  = map[string]HealthStatus{}
+	)
+	for name, checker := range checkers {
+		wg.Add(1)
+		go func(name string, checker HealthChecker) {
+			defer wg.Done()
+			start := time.Now()
+			err := checker.Check(ctx)
+			status := HealthStatus{Status: healthUp, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = healthDown
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report[name] = status
+			healthy = healthy && err == nil
+		}(name, checker)
+	}
+	wg.Wait()
+	return report, healthy
+}
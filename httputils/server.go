@@ -9,6 +9,7 @@ import (
 	"log"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 
 	"github.com/babyfaceEasy/commons/commonerror"
 	"github.com/babyfaceEasy/commons/uuid"
@@ -22,6 +23,9 @@ const (
 	failureMessage  = "error"
 	noReqBody       = "EOF"
 	maxUploadMemory = 20000000
+	cursorKey       = "cursor"
+	limitKey        = "limit"
+	skipKey         = "skip"
 )
 
 // GenericResponse is a representation of a server response
@@ -39,6 +43,13 @@ type FileDetails struct {
 	ContentType string `json:"contentType"`
 }
 
+// PageParams is a representation of the pagination query parameters of a request
+type PageParams struct {
+	Cursor string
+	Limit  int64
+	Skip   int64
+}
+
 // FileWithBodyResult is a representation of the result of extracting both text and file uploads from a request
 type FileWithBodyResult struct {
 	Files []FileDetails
@@ -112,6 +123,27 @@ func serveBadRequestError(err error, w http.ResponseWriter) {
 	w.Write(bb)
 }
 
+// served when the resource was changed by another request
+func serveConflictError(err error, w http.ResponseWriter) {
+	log.Println("Error is: ", err)
+
+	errDTO, ok := errors.Cause(err).(commonerror.Error)
+	if !ok {
+		errDTO = commonerror.Error{
+			Message: commonerror.ErrorMessage(err.Error()),
+		}
+	}
+	res := NewErrorResponse(errDTO)
+	bb, err := json.Marshal(res)
+	if err != nil {
+		serveInternalError(err, w)
+	}
+
+	setStandardHeaders(w)
+	w.WriteHeader(http.StatusConflict)
+	w.Write(bb)
+}
+
 // served when user did not provide authorization
 func serveUnauthorizedResponse(err error, w http.ResponseWriter) {
 	log.Println("Error is: ", err)
@@ -252,6 +284,9 @@ func ServeError(err error, w http.ResponseWriter) {
 	case commonerror.IsUnAuthenticatedError(err):
 		serveAuthenticationErrResponse(err, w)
 		return
+	case commonerror.IsConflictError(err):
+		serveConflictError(err, w)
+		return
 	default:
 		serveInternalError(err, w)
 		return
@@ -305,10 +340,25 @@ func ServeFile(w http.ResponseWriter, fileName string, data []byte) {
 	tee := io.TeeReader(bytes.NewReader(data), &buf)
 	contentType := GetFileContentType(tee)
 
+	setFileHeaders(w, fileName, contentType)
+	w.Write(data)
+}
+
+// ServeFileStream returns a File Download Capability for an http request, copying the file from r without holding it in memory
+// The size is sent as the Content-Length when it is known, pass in -1 otherwise
+func ServeFileStream(w http.ResponseWriter, fileName, contentType string, size int64, r io.Reader) error {
+	setFileHeaders(w, fileName, contentType)
+	if size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	_, err := io.Copy(w, r)
+	return err
+}
+
+func setFileHeaders(w http.ResponseWriter, fileName, contentType string) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", contentType)
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, fileName))
-	w.Write(data)
 }
 
 // RetrieveUUIDResource retrieves a resource of type uuid from an incoming request
@@ -324,3 +374,29 @@ func RetrieveUUIDResource(r *http.Request, idKey string) (uuid.V4, error) {
 	}
 	return uID, nil
 }
+
+// RetrievePageParams retrieves the pagination query parameters (cursor, skip and limit) from an incoming request
+// defaultLimit is used when no limit is passed in
+func RetrievePageParams(r *http.Request, defaultLimit int64) (PageParams, error) {
+	q := r.URL.Query()
+	p := PageParams{
+		Cursor: q.Get(cursorKey),
+		Limit:  defaultLimit,
+	}
+
+	if v := q.Get(limitKey); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || limit <= 0 {
+			return PageParams{}, commonerror.NewErrorParams(limitKey, "Invalid limit. Expected a positive number").ToBadRequest()
+		}
+		p.Limit = limit
+	}
+	if v := q.Get(skipKey); v != "" {
+		skip, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || skip < 0 {
+			return PageParams{}, commonerror.NewErrorParams(skipKey, "Invalid skip. Expected a non-negative number").ToBadRequest()
+		}
+		p.Skip = skip
+	}
+	return p, nil
+}
@@ -0,0 +1,180 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/babyfaceEasy/commons/mongo"
+	driver "go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultStepTimeout = 10 * time.Second
+
+// Closer releases a resource, giving up once ctx is done
+type Closer func(ctx context.Context) error
+
+// step is a registered closer
+type step struct {
+	name    string
+	timeout time.Duration
+	close   Closer
+}
+
+// StepError is the failure of a shutdown step
+type StepError struct {
+	Name string
+	Err  error
+}
+
+// ShutdownError lists the steps that failed during shutdown
+type ShutdownError struct {
+	Steps []StepError
+}
+
+func (e *ShutdownError) Error() string {
+	msgs := make([]string, 0, len(e.Steps))
+	for _, s := range e.Steps {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", s.Name, s.Err))
+	}
+	return "shutdown failed: " + strings.Join(msgs, "; ")
+}
+
+// Manager shuts down registered resources in order when the process is asked to stop
+type Manager struct {
+	mu       sync.Mutex
+	steps    []step
+	once     sync.Once
+	err      error
+	shutdown chan struct{}
+	finished chan struct{}
+}
+
+// NewManager creates a manager with no registered closers
+func NewManager() *Manager {
+	return &Manager{shutdown: make(chan struct{}), finished: make(chan struct{})}
+}
+
+// Register adds a closer, run once every closer registered before it is done
+// Register HTTP servers first so no new work arrives, then background workers, then mongo.
+// The closer gets at most timeout to complete, 10 seconds when timeout is 0
+func (m *Manager) Register(name string, timeout time.Duration, c Closer) {
+	if timeout <= 0 {
+		timeout = defaultStepTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.steps = append(m.steps, step{name: name, timeout: timeout, close: c})
+}
+
+// Wait blocks until the process receives SIGTERM or SIGINT, or ctx is done, then shuts down
+func (m *Manager) Wait(ctx context.Context) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	select {
+	case sig := <-sigs:
+		log.Printf("Received %s, shutting down", sig)
+	case <-ctx.Done():
+		log.Println("Shutting down with error: ", ctx.Err())
+	case <-m.shutdown:
+	}
+	return m.Shutdown(context.Background())
+}
+
+// Shutdown runs the closers in the order they were registered, each within its timeout
+// Every closer runs even when an earlier one fails, the failures are returned as a *ShutdownError.
+// Only the first call shuts down, later calls return its result
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.once.Do(func() {
+		close(m.shutdown)
+		defer close(m.finished)
+
+		m.mu.Lock()
+		steps := append([]step{}, m.steps...)
+		m.mu.Unlock()
+
+		failed := []StepError{}
+		for _, s := range steps {
+			start := time.Now()
+			if err := runStep(ctx, s); err != nil {
+				log.Printf("Shutdown of %s failed after %s with error: %v", s.name, time.Since(start), err)
+				failed = append(failed, StepError{Name: s.name, Err: err})
+				continue
+			}
+			log.Printf("Shut down %s in %s", s.name, time.Since(start))
+		}
+		if len(failed) > 0 {
+			m.err = &ShutdownError{Steps: failed}
+		}
+	})
+	<-m.finished
+	return m.err
+}
+
+// Done is closed once shutdown starts
+func (m *Manager) Done() <-chan struct{} {
+	return m.shutdown
+}
+
+// runStep runs a closer, giving up once its timeout passes even if the closer ignores ctx
+func runStep(ctx context.Context, s step) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.close(ctx)
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", s.timeout)
+	}
+}
+
+// HTTPServer returns a closer that stops the server accepting requests and waits for those in flight
+func HTTPServer(srv *http.Server) Closer {
+	return func(ctx context.Context) error {
+		return srv.Shutdown(ctx)
+	}
+}
+
+// Mongo returns a closer that disconnects the client, waiting for operations in progress until the step times out
+// A provider's mongo.DisconnectFunc can be registered as a closer directly
+func Mongo(client *driver.Client) Closer {
+	return func(ctx context.Context) error {
+		return mongo.DisconnectMongoContext(ctx, client)
+	}
+}
+
+// Func returns a closer for a function that can not be cancelled and reports no error.
+// Use Mongo or a mongo.DisconnectFunc rather than wrapping a mongo.CloseMongoFunc, so disconnect failures are reported
+func Func(f func()) Closer {
+	return func(ctx context.Context) error {
+		f()
+		return nil
+	}
+}
+
+// Worker returns a closer that cancels a background worker and waits for it to signal it is done by closing done
+func Worker(cancel context.CancelFunc, done <-chan struct{}) Closer {
+	return func(ctx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
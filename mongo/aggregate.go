@@ -0,0 +1,204 @@
+package mongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Pipeline builds the stages of an aggregation
+type Pipeline struct {
+	stages mongo.Pipeline
+}
+
+// NewPipeline creates an empty aggregation pipeline
+func NewPipeline() *Pipeline {
+	return &Pipeline{stages: mongo.Pipeline{}}
+}
+
+// Match filters the documents passed to the next stage. A Query can be passed in as the filter
+func (p *Pipeline) Match(filter interface{}) *Pipeline {
+	return p.Stage("$match", filter)
+}
+
+// Group groups documents by the id expression, computing the accumulators for each group
+// e.g Group("$status", bson.M{"total": Sum("$amount")})
+func (p *Pipeline) Group(id interface{}, accumulators bson.M) *Pipeline {
+	group := bson.D{{Key: "_id", Value: id}}
+	for k, v := range accumulators {
+		group = append(group, bson.E{Key: k, Value: v})
+	}
+	return p.Stage("$group", group)
+}
+
+// Lookup joins the documents of another collection whose foreignField equals localField, into the as field
+func (p *Pipeline) Lookup(from, localField, foreignField, as string) *Pipeline {
+	return p.Stage("$lookup", bson.D{
+		{Key: "from", Value: from},
+		{Key: "localField", Value: localField},
+		{Key: "foreignField", Value: foreignField},
+		{Key: "as", Value: as},
+	})
+}
+
+// Unwind outputs a document for each element of the array at path e.g "$items"
+// Documents whose array is missing or empty are kept when preserveEmpty is true
+func (p *Pipeline) Unwind(path string, preserveEmpty bool) *Pipeline {
+	return p.Stage("$unwind", bson.D{
+		{Key: "path", Value: path},
+		{Key: "preserveNullAndEmptyArrays", Value: preserveEmpty},
+	})
+}
+
+// Sort orders the documents by field. Consecutive calls add fields to the same sort, the first taking precedence
+// A $sort stage added with Stage is only extended when it is a bson.D, as other maps have no field order
+func (p *Pipeline) Sort(field string, order SortOrder) *Pipeline {
+	key := bson.E{Key: field, Value: int(order)}
+	if n := len(p.stages); n > 0 && len(p.stages[n-1]) > 0 && p.stages[n-1][0].Key == "$sort" {
+		if sort, ok := p.stages[n-1][0].Value.(bson.D); ok {
+			p.stages[n-1][0].Value = append(sort, key)
+			return p
+		}
+	}
+	return p.Stage("$sort", bson.D{key})
+}
+
+// Facet runs each sub pipeline on the same input documents, outputting their results under the facet names
+func (p *Pipeline) Facet(facets map[string]*Pipeline) *Pipeline {
+	stage := bson.D{}
+	for name, sub := range facets {
+		stage = append(stage, bson.E{Key: name, Value: sub.Stages()})
+	}
+	return p.Stage("$facet", stage)
+}
+
+// Count outputs a single document with the number of input documents in field
+func (p *Pipeline) Count(field string) *Pipeline {
+	return p.Stage("$count", field)
+}
+
+// Project reshapes the documents
+func (p *Pipeline) Project(projection interface{}) *Pipeline {
+	return p.Stage("$project", projection)
+}
+
+// Skip skips the first n documents
+func (p *Pipeline) Skip(n int64) *Pipeline {
+	return p.Stage("$skip", n)
+}
+
+// Limit passes on only the first n documents
+func (p *Pipeline) Limit(n int64) *Pipeline {
+	return p.Stage("$limit", n)
+}
+
+// Stage adds a stage the builder has no helper for e.g Stage("$sample", bson.M{"size": 10})
+func (p *Pipeline) Stage(name string, spec interface{}) *Pipeline {
+	p.stages = append(p.stages, bson.D{{Key: name, Value: spec}})
+	return p
+}
+
+// Stages returns the stages of the pipeline
+func (p *Pipeline) Stages() mongo.Pipeline {
+	return p.stages
+}
+
+// Sum is the $sum accumulator of an expression e.g Sum("$amount") or Sum(1) to count
+func Sum(expr interface{}) bson.M {
+	return bson.M{"$sum": expr}
+}
+
+// Avg is the $avg accumulator of an expression
+func Avg(expr interface{}) bson.M {
+	return bson.M{"$avg": expr}
+}
+
+// Min is the $min accumulator of an expression
+func Min(expr interface{}) bson.M {
+	return bson.M{"$min": expr}
+}
+
+// Max is the $max accumulator of an expression
+func Max(expr interface{}) bson.M {
+	return bson.M{"$max": expr}
+}
+
+// Push is the $push accumulator, collecting the expression of each document of a group into an array
+func Push(expr interface{}) bson.M {
+	return bson.M{"$push": expr}
+}
+
+// First is the $first accumulator, the expression of the first document of a group
+func First(expr interface{}) bson.M {
+	return bson.M{"$first": expr}
+}
+
+// Aggregate runs the pipeline on the collection
+// the 'onEach' function is called for each result as the cursor iterates
+func (c Collection) Aggregate(p *Pipeline, onEach func(c *mongo.Cursor) error) error {
+	ctx := c.getContext()
+
+	pipeline, err := c.scopePipeline(p)
+	if err != nil {
+		return err
+	}
+	cur, err := c.col.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	return eachDoc(ctx, cur, c.decrypting(onEach))
+}
+
+// AggregateAll runs the pipeline on the collection and decodes all results into a slice of T
+func AggregateAll[T any](c Collection, p *Pipeline) ([]T, error) {
+	results := []T{}
+	err := c.Aggregate(p, func(cur *mongo.Cursor) error {
+		var r T
+		if err := cur.Decode(&r); err != nil {
+			return err
+		}
+		results = append(results, r)
+		return nil
+	})
+	return results, err
+}
+
+// scopePipeline excludes soft deleted documents at the start of the pipeline, see WithSoftDelete,
+// and encrypts the values of encrypted fields in the leading $match stages
+func (c Collection) scopePipeline(p *Pipeline) (mongo.Pipeline, error) {
+	stages, err := c.encryptLeadingMatches(p.Stages())
+	if err != nil {
+		return nil, err
+	}
+	if !c.audit.softDelete || c.audit.includeDeleted {
+		return stages, nil
+	}
+	match := bson.D{{Key: "$match", Value: bson.M{deletedAtField: nil}}}
+	// $geoNear must be the first stage, so deleted documents are excluded right after it
+	if len(stages) > 0 && len(stages[0]) > 0 && stages[0][0].Key == "$geoNear" {
+		return append(mongo.Pipeline{stages[0], match}, stages[1:]...), nil
+	}
+	return append(mongo.Pipeline{match}, stages...), nil
+}
+
+// encryptLeadingMatches encrypts the $match stages the documents reach as stored. Later stages may match
+// reshaped documents, so they are passed on as they are
+func (c Collection) encryptLeadingMatches(stages mongo.Pipeline) (mongo.Pipeline, error) {
+	if c.enc == nil {
+		return stages, nil
+	}
+	out := append(mongo.Pipeline{}, stages...)
+	for i, stage := range out {
+		if len(stage) == 0 || (stage[0].Key != "$match" && !(i == 0 && stage[0].Key == "$geoNear")) {
+			break
+		}
+		if stage[0].Key != "$match" {
+			continue
+		}
+		filter, err := c.encryptScope(stage[0].Value)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = bson.D{{Key: "$match", Value: filter}}
+	}
+	return out, nil
+}
@@ -0,0 +1,230 @@
+package mongo
+
+import (
+	"github.com/babyfaceEasy/commons/ctime"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	createdAtField = "createdAt"
+	updatedAtField = "updatedAt"
+	deletedAtField = "deletedAt"
+)
+
+// TimestampFormat is the format audit timestamps are stored in
+type TimestampFormat int
+
+const (
+	// TimestampISO8601 stores timestamps as ctime.ISO8601 strings e.g 2006-01-02T15:04:05.629Z
+	TimestampISO8601 TimestampFormat = iota
+	// TimestampEpoch stores timestamps as ctime.Epoch nano seconds
+	TimestampEpoch
+)
+
+// auditConfig holds the audit behaviour of a collection
+type auditConfig struct {
+	timestamps     bool
+	softDelete     bool
+	includeDeleted bool
+	format         TimestampFormat
+}
+
+// WithTimestamps returns a copy of the collection that stamps createdAt on inserts and updatedAt on every write
+func (c Collection) WithTimestamps(format TimestampFormat) Collection {
+	c.audit.timestamps = true
+	c.audit.format = format
+	return c
+}
+
+// WithSoftDelete returns a copy of the collection whose deletes set deletedAt instead of removing documents
+// Soft deleted documents are excluded from finders and writes, see IncludeDeleted
+func (c Collection) WithSoftDelete() Collection {
+	c.audit.softDelete = true
+	return c
+}
+
+// IncludeDeleted returns a copy of the collection whose finders also return soft deleted documents
+func (c Collection) IncludeDeleted() Collection {
+	c.audit.includeDeleted = true
+	return c
+}
+
+// HardDelete removes a document from the collection, even when soft deletes are on
+func (c Collection) HardDelete(ID string) error {
+	_, err := c.col.DeleteOne(c.getContext(), bson.M{"id": ID})
+	return err
+}
+
+// Restore clears the deletedAt marker of a soft deleted document
+func (c Collection) Restore(ID string) error {
+	filter := bson.M{"id": ID}
+	update := bson.M{"$unset": bson.M{deletedAtField: ""}}
+	if c.audit.timestamps {
+		update["$set"] = bson.M{updatedAtField: c.now()}
+	}
+	_, err := c.col.UpdateOne(c.getContext(), filter, update)
+	return err
+}
+
+// now returns the current time in the collection's timestamp format
+func (c Collection) now() interface{} {
+	if c.audit.format == TimestampEpoch {
+		return ctime.CurrentEpoch()
+	}
+	return ctime.CurrentEpoch().ToISO8601().String()
+}
+
+// scope restricts a filter to the documents that are not soft deleted, encrypting the values of encrypted fields
+func (c Collection) scope(filter interface{}) (interface{}, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	filter, err := c.encryptScope(filter)
+	if err != nil {
+		return nil, err
+	}
+	if !c.audit.softDelete || c.audit.includeDeleted {
+		return filter, nil
+	}
+	return bson.M{"$and": bson.A{filter, bson.M{deletedAtField: nil}}}, nil
+}
+
+// stampInsert sets createdAt, updatedAt and the initial version on a document about to be inserted
+func (c Collection) stampInsert(doc interface{}) (interface{}, error) {
+	fields := []bson.E{}
+	if c.audit.timestamps {
+		now := c.now()
+		fields = append(fields, bson.E{Key: createdAtField, Value: now}, bson.E{Key: updatedAtField, Value: now})
+	}
+	if c.versionField != "" {
+		fields = append(fields, bson.E{Key: c.versionField, Value: initialVersion})
+	}
+	if len(fields) == 0 {
+		return c.encryptFields(doc)
+	}
+	d, err := withFields(doc, fields...)
+	if err != nil {
+		return nil, err
+	}
+	return c.encryptFields(d)
+}
+
+// stampSet sets updatedAt on the changes of a $set update
+// The version field is removed from the changes as it is incremented by the update, see updateDoc
+func (c Collection) stampSet(changes interface{}) (interface{}, error) {
+	if !c.audit.timestamps && c.versionField == "" {
+		return c.encryptFields(changes)
+	}
+	d, err := withFields(changes)
+	if err != nil {
+		return nil, err
+	}
+	if c.audit.timestamps {
+		d = setField(d, bson.E{Key: updatedAtField, Value: c.now()})
+	}
+	if c.versionField != "" {
+		d = removeField(d, c.versionField)
+	}
+	return c.encryptFields(d)
+}
+
+// replaceUpdate returns the update replacing a document in a single write. With timestamps or versioning on it is
+// a pipeline keeping the createdAt of the replaced document and incrementing its version, and pipeline is true.
+// Otherwise it is the replacement itself, to use as a replace
+func (c Collection) replaceUpdate(replacement interface{}) (update interface{}, pipeline bool, err error) {
+	if !c.audit.timestamps && c.versionField == "" {
+		doc, err := c.encryptFields(replacement)
+		return doc, false, err
+	}
+
+	d, err := withFields(replacement)
+	if err != nil {
+		return nil, false, err
+	}
+	stamps := bson.D{}
+	if c.audit.timestamps {
+		now := c.now()
+		d = removeField(removeField(d, createdAtField), updatedAtField)
+		stamps = append(stamps,
+			bson.E{Key: createdAtField, Value: bson.M{"$ifNull": bson.A{"$" + createdAtField, now}}},
+			bson.E{Key: updatedAtField, Value: now},
+		)
+	}
+	if c.versionField != "" {
+		d = removeField(d, c.versionField)
+		// an upsert inserting a new document starts it at initialVersion
+		version := bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$" + c.versionField, initialVersion - 1}}, int64(1)}}
+		stamps = append(stamps, bson.E{Key: c.versionField, Value: version})
+	}
+	doc, err := c.encryptFields(d)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// the replacement is a $literal so its values are not read as expressions
+	merged := bson.M{"$mergeObjects": bson.A{bson.M{"$literal": doc}, stamps}}
+	return mongo.Pipeline{{{Key: "$replaceWith", Value: merged}}}, true, nil
+}
+
+// replaceOne replaces the document matching the filter, inserting it when there is none and upsert is set
+func (c Collection) replaceOne(filter interface{}, replacement interface{}, upsert bool) (*mongo.UpdateResult, error) {
+	update, pipeline, err := c.replaceUpdate(replacement)
+	if err != nil {
+		return nil, err
+	}
+	if pipeline {
+		return c.col.UpdateOne(c.getContext(), filter, update, options.Update().SetUpsert(upsert))
+	}
+	return c.col.ReplaceOne(c.getContext(), filter, update, options.Replace().SetUpsert(upsert))
+}
+
+// updateDoc builds the update applying changes, incrementing the version when versioning is on
+func (c Collection) updateDoc(changes interface{}) bson.D {
+	update := bson.D{{Key: "$set", Value: changes}}
+	if c.versionField != "" {
+		update = append(update, bson.E{Key: "$inc", Value: bson.M{c.versionField: 1}})
+	}
+	return update
+}
+
+// withFields returns the document with the fields set, replacing any existing values
+func withFields(doc interface{}, fields ...bson.E) (bson.D, error) {
+	bb, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal document")
+	}
+	var d bson.D
+	if err := bson.Unmarshal(bb, &d); err != nil {
+		return nil, errors.Wrap(err, "unable to unmarshal document")
+	}
+
+	for _, f := range fields {
+		d = setField(d, f)
+	}
+	return d, nil
+}
+
+// setField sets a field of a document, replacing any existing value
+func setField(d bson.D, f bson.E) bson.D {
+	for i, e := range d {
+		if e.Key == f.Key {
+			d[i].Value = f.Value
+			return d
+		}
+	}
+	return append(d, f)
+}
+
+// removeField removes a field from a document
+func removeField(d bson.D, key string) bson.D {
+	out := bson.D{}
+	for _, e := range d {
+		if e.Key != key {
+			out = append(out, e)
+		}
+	}
+	return out
+}
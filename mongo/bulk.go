@@ -0,0 +1,186 @@
+package mongo
+
+import (
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// bulkOpKind is the kind of write of a bulk operation
+type bulkOpKind int
+
+const (
+	bulkInsert bulkOpKind = iota
+	bulkUpdate
+	bulkReplace
+	bulkUpsert
+	bulkDelete
+)
+
+// BulkOp is a single write within a bulk write, see InsertOp, UpdateOp, ReplaceOp, UpsertOp and DeleteOp
+type BulkOp struct {
+	kind   bulkOpKind
+	filter interface{}
+	doc    interface{}
+}
+
+// BulkItemError is the failure of a single operation of a bulk write
+type BulkItemError struct {
+	Index   int    `json:"index"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// BulkResult summarises the outcome of a bulk write
+type BulkResult struct {
+	Inserted int64 `json:"inserted"`
+	Matched  int64 `json:"matched"`
+	Modified int64 `json:"modified"`
+	Upserted int64 `json:"upserted"`
+	Deleted  int64 `json:"deleted"`
+	// UpsertedIDs maps the index of each upserting operation to the _id of the document it inserted
+	UpsertedIDs map[int64]interface{} `json:"upsertedIDs,omitempty"`
+	Errors      []BulkItemError       `json:"errors,omitempty"`
+}
+
+// InsertOp inserts a new document
+func InsertOp(doc interface{}) BulkOp {
+	return BulkOp{kind: bulkInsert, doc: doc}
+}
+
+// UpdateOp sets the changes on the document with the given ID
+func UpdateOp(ID string, changes interface{}) BulkOp {
+	return BulkOp{kind: bulkUpdate, filter: bson.M{"id": ID}, doc: changes}
+}
+
+// ReplaceOp replaces the document with the given ID
+func ReplaceOp(ID string, replacement interface{}) BulkOp {
+	return BulkOp{kind: bulkReplace, filter: bson.M{"id": ID}, doc: replacement}
+}
+
+// UpsertOp replaces the document with the given ID, inserting it when there is none. See Collection.Upsert
+func UpsertOp(ID string, doc interface{}) BulkOp {
+	return UpsertWithFilterOp(bson.M{"id": ID}, doc)
+}
+
+// UpsertWithFilterOp replaces the document matching the filter, inserting it when there is none
+func UpsertWithFilterOp(filter interface{}, doc interface{}) BulkOp {
+	return BulkOp{kind: bulkUpsert, filter: filter, doc: doc}
+}
+
+// DeleteOp deletes the document with the given ID
+func DeleteOp(ID string) BulkOp {
+	return BulkOp{kind: bulkDelete, filter: bson.M{"id": ID}}
+}
+
+// Upsert replaces the document with the given ID, inserting it when there is none
+// A soft deleted document with the ID is replaced, and so restored, as its ID is still taken
+func (c Collection) Upsert(ID string, doc interface{}) (*mongo.UpdateResult, error) {
+	return c.UpsertWithFilter(bson.M{"id": ID}, doc)
+}
+
+// UpsertWithFilter replaces the document matching the filter, inserting it when there is none
+// Soft deleted documents are matched too and restored by the replacement, as inserting one alongside them would
+// collide on the unique id index
+func (c Collection) UpsertWithFilter(filter interface{}, doc interface{}) (*mongo.UpdateResult, error) {
+	filter, err := c.upsertScope(filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.replaceOne(filter, doc, true)
+}
+
+// BulkWrite runs the operations in a single request
+// Ordered writes stop at the first failing operation, unordered writes attempt every operation.
+// Failures of single operations are reported in BulkResult.Errors rather than as an error
+func (c Collection) BulkWrite(ops []BulkOp, ordered bool) (BulkResult, error) {
+	if len(ops) == 0 {
+		return BulkResult{}, nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(ops))
+	for i, op := range ops {
+		model, err := c.writeModel(op)
+		if err != nil {
+			return BulkResult{}, errors.Wrapf(err, "unable to prepare bulk operation %d", i)
+		}
+		models = append(models, model)
+	}
+
+	res, err := c.col.BulkWrite(c.getContext(), models, options.BulkWrite().SetOrdered(ordered))
+	result := toBulkResult(res)
+
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			result.Errors = append(result.Errors, BulkItemError{Index: we.Index, Code: we.Code, Message: we.Message})
+		}
+		return result, nil
+	}
+	return result, err
+}
+
+func (c Collection) writeModel(op BulkOp) (mongo.WriteModel, error) {
+	if op.kind == bulkInsert {
+		doc, err := c.stampInsert(op.doc)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewInsertOneModel().SetDocument(doc), nil
+	}
+
+	filter, err := c.scope(op.filter)
+	if op.kind == bulkUpsert {
+		filter, err = c.upsertScope(op.filter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch op.kind {
+	case bulkUpdate:
+		changes, err := c.stampSet(op.doc)
+		if err != nil {
+			return nil, err
+		}
+		return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(c.updateDoc(changes)), nil
+	case bulkReplace, bulkUpsert:
+		update, pipeline, err := c.replaceUpdate(op.doc)
+		if err != nil {
+			return nil, err
+		}
+		if pipeline {
+			return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update).SetUpsert(op.kind == bulkUpsert), nil
+		}
+		return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(update).SetUpsert(op.kind == bulkUpsert), nil
+	case bulkDelete:
+		if c.audit.softDelete {
+			update := c.updateDoc(bson.M{deletedAtField: c.now()})
+			return mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update), nil
+		}
+		return mongo.NewDeleteOneModel().SetFilter(op.filter), nil
+	}
+	return nil, errors.Errorf("unknown bulk operation kind %d", op.kind)
+}
+
+// upsertScope is the filter of an upsert, which matches soft deleted documents
+func (c Collection) upsertScope(filter interface{}) (interface{}, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	return c.encryptScope(filter)
+}
+
+func toBulkResult(res *mongo.BulkWriteResult) BulkResult {
+	if res == nil {
+		return BulkResult{}
+	}
+	return BulkResult{
+		Inserted:    res.InsertedCount,
+		Matched:     res.MatchedCount,
+		Modified:    res.ModifiedCount,
+		Upserted:    res.UpsertedCount,
+		Deleted:     res.DeletedCount,
+		UpsertedIDs: res.UpsertedIDs,
+	}
+}
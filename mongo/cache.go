@@ -0,0 +1,325 @@
+package mongo
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Cache stores documents as bson, keyed by lookup
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte)
+	Delete(key string)
+}
+
+// CacheStats counts the lookups of a cached collection
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+// HitRatio returns the share of lookups served from the cache
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// cacheState is shared by the copies of a cached collection
+type cacheState struct {
+	cache         Cache
+	hits          int64
+	misses        int64
+	invalidations int64
+
+	mu sync.Mutex
+	// keysByID lists the cache keys holding each document, so all lookups of a document are invalidated together
+	keysByID map[string]map[string]bool
+	// generation is bumped by every invalidation, so a lookup that raced with a write does not cache what it read
+	generation uint64
+}
+
+// CachedCollection is a collection whose FindByID and FindByField lookups are read through a cache
+// Writes made through it invalidate the cached documents they touch, writes made elsewhere are only seen once the
+// cached entries expire. Encrypted fields are cached decrypted
+type CachedCollection struct {
+	Collection
+	state *cacheState
+}
+
+var _ CollectionAPI = &CachedCollection{}
+
+// WithCache returns a copy of the collection whose lookups are read through cache, e.g NewLRUCache(1000, time.Minute)
+// WithCache must be the last option applied, as the other options return an uncached Collection
+func (c Collection) WithCache(cache Cache) CachedCollection {
+	return CachedCollection{
+		Collection: c,
+		state:      &cacheState{cache: cache, keysByID: map[string]map[string]bool{}},
+	}
+}
+
+// WithContext returns a copy of the collection whose operations run with ctx, sharing the cache
+func (c CachedCollection) WithContext(ctx context.Context) CachedCollection {
+	c.Collection = c.Collection.WithContext(ctx)
+	return c
+}
+
+// Stats returns the lookup statistics of the collection
+func (c CachedCollection) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.state.hits),
+		Misses:        atomic.LoadInt64(&c.state.misses),
+		Invalidations: atomic.LoadInt64(&c.state.invalidations),
+	}
+}
+
+// FindByID finds by ID, a document in the cache or else in the mongo database
+func (c CachedCollection) FindByID(ID string, r interface{}) error {
+	return c.readThrough("id:"+ID, r, func(raw *bson.Raw) error {
+		return c.Collection.FindByID(ID, raw)
+	})
+}
+
+// FindByField finds by a specific field, the FIRST document in the cache or else in the mongo database
+func (c CachedCollection) FindByField(key string, value string, r interface{}) error {
+	return c.readThrough("field:"+key+"="+value, r, func(raw *bson.Raw) error {
+		return c.Collection.FindByField(key, value, raw)
+	})
+}
+
+// Replace replaces an existing document in the database
+func (c CachedCollection) Replace(ID string, replacement interface{}) (*mongo.UpdateResult, error) {
+	defer c.invalidate(ID)
+	return c.Collection.Replace(ID, replacement)
+}
+
+// ReplaceWithFilter replaces an existing document, given a filter, in the database
+func (c CachedCollection) ReplaceWithFilter(key, value string, replacement interface{}) (*mongo.UpdateResult, error) {
+	defer c.invalidateAll()
+	return c.Collection.ReplaceWithFilter(key, value, replacement)
+}
+
+// Update updates a specific field in an existing document in the database
+func (c CachedCollection) Update(ID string, key string, u interface{}) (*mongo.UpdateResult, error) {
+	defer c.invalidate(ID)
+	return c.Collection.Update(ID, key, u)
+}
+
+// UpdateObject updates existing document in the database
+func (c *CachedCollection) UpdateObject(id string, changes interface{}) error {
+	defer c.invalidate(id)
+	return c.Collection.UpdateObject(id, changes)
+}
+
+// UpdateOneWithFilterOptions updates with filter conditions specified
+func (c *CachedCollection) UpdateOneWithFilterOptions(filter interface{}, changes interface{}) error {
+	defer c.invalidateAll()
+	return c.Collection.UpdateOneWithFilterOptions(filter, changes)
+}
+
+// UpdateIfVersion sets the changes on the document with the given ID if it is still at version
+func (c CachedCollection) UpdateIfVersion(ID string, version int64, changes interface{}) error {
+	defer c.invalidate(ID)
+	return c.Collection.UpdateIfVersion(ID, version, changes)
+}
+
+// ReplaceIfVersion replaces the document with the given ID if it is still at version
+func (c CachedCollection) ReplaceIfVersion(ID string, version int64, replacement interface{}) error {
+	defer c.invalidate(ID)
+	return c.Collection.ReplaceIfVersion(ID, version, replacement)
+}
+
+// Upsert replaces the document with the given ID, inserting it when there is none
+func (c CachedCollection) Upsert(ID string, doc interface{}) (*mongo.UpdateResult, error) {
+	defer c.invalidate(ID)
+	return c.Collection.Upsert(ID, doc)
+}
+
+// UpsertWithFilter replaces the document matching the filter, inserting it when there is none
+func (c CachedCollection) UpsertWithFilter(filter interface{}, doc interface{}) (*mongo.UpdateResult, error) {
+	defer c.invalidateAll()
+	return c.Collection.UpsertWithFilter(filter, doc)
+}
+
+// BulkWrite runs the operations in a single request
+func (c CachedCollection) BulkWrite(ops []BulkOp, ordered bool) (BulkResult, error) {
+	defer c.invalidateAll()
+	return c.Collection.BulkWrite(ops, ordered)
+}
+
+// Delete deletes a document from a collection in the data
+func (c CachedCollection) Delete(ID string) error {
+	defer c.invalidate(ID)
+	return c.Collection.Delete(ID)
+}
+
+// HardDelete removes a document from the collection, even when soft deletes are on
+func (c CachedCollection) HardDelete(ID string) error {
+	defer c.invalidate(ID)
+	return c.Collection.HardDelete(ID)
+}
+
+// Restore clears the deletedAt marker of a soft deleted document
+func (c CachedCollection) Restore(ID string) error {
+	defer c.invalidate(ID)
+	return c.Collection.Restore(ID)
+}
+
+// readThrough decodes the cached document of a lookup into r, running the lookup and caching its result on a miss
+func (c CachedCollection) readThrough(lookup string, r interface{}, find func(raw *bson.Raw) error) error {
+	key := c.cacheKey(lookup)
+	if bb, ok := c.state.cache.Get(key); ok {
+		atomic.AddInt64(&c.state.hits, 1)
+		return bson.Unmarshal(bb, r)
+	}
+	atomic.AddInt64(&c.state.misses, 1)
+
+	c.state.mu.Lock()
+	generation := c.state.generation
+	c.state.mu.Unlock()
+
+	var raw bson.Raw
+	if err := find(&raw); err != nil {
+		return err
+	}
+	if id, ok := raw.Lookup("id").StringValueOK(); ok {
+		c.state.mu.Lock()
+		// a write invalidated the cache while the document was read, it may be stale so is not cached
+		if generation == c.state.generation {
+			c.state.cache.Set(key, raw)
+			if c.state.keysByID[id] == nil {
+				c.state.keysByID[id] = map[string]bool{}
+			}
+			c.state.keysByID[id][key] = true
+		}
+		c.state.mu.Unlock()
+	}
+	return bson.Unmarshal(raw, r)
+}
+
+// cacheKey namespaces a lookup by database and collection, so collections of different tenants can share a cache
+func (c CachedCollection) cacheKey(lookup string) string {
+	return c.col.Database().Name() + "." + c.col.Name() + ":" + lookup
+}
+
+// invalidate removes every cached lookup of the document with the given ID
+func (c CachedCollection) invalidate(ID string) {
+	c.state.mu.Lock()
+	c.state.generation++
+	keys := c.state.keysByID[ID]
+	delete(c.state.keysByID, ID)
+	c.state.mu.Unlock()
+
+	// a lookup by id may have missed before the write, and be cached under its key alone
+	c.state.cache.Delete(c.cacheKey("id:" + ID))
+	for key := range keys {
+		c.state.cache.Delete(key)
+	}
+	atomic.AddInt64(&c.state.invalidations, 1)
+}
+
+// invalidateAll removes every cached lookup, for writes whose documents are not known
+func (c CachedCollection) invalidateAll() {
+	c.state.mu.Lock()
+	c.state.generation++
+	all := c.state.keysByID
+	c.state.keysByID = map[string]map[string]bool{}
+	c.state.mu.Unlock()
+
+	for _, keys := range all {
+		for key := range keys {
+			c.state.cache.Delete(key)
+		}
+	}
+	atomic.AddInt64(&c.state.invalidations, 1)
+}
+
+// lruEntry is a cached value
+type lruEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+// LRUCache is an in process Cache holding a bounded number of entries, each for a limited time
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+// NewLRUCache creates a cache of at most capacity entries, evicting the least recently used first.
+// Entries expire ttl after they are set, they never expire when ttl is 0
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	return &LRUCache{capacity: capacity, ttl: ttl, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns the value of key, unless it expired or was evicted
+func (l *LRUCache) Get(key string) ([]byte, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*lruEntry)
+	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
+		l.remove(el)
+		return nil, false
+	}
+	l.order.MoveToFront(el)
+	return e.value, true
+}
+
+// Set stores the value of key, evicting the least recently used entry when the cache is full
+func (l *LRUCache) Set(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var expiresAt time.Time
+	if l.ttl > 0 {
+		expiresAt = time.Now().Add(l.ttl)
+	}
+	if el, ok := l.entries[key]; ok {
+		el.Value = &lruEntry{key: key, value: value, expiresAt: expiresAt}
+		l.order.MoveToFront(el)
+		return
+	}
+	l.entries[key] = l.order.PushFront(&lruEntry{key: key, value: value, expiresAt: expiresAt})
+	for l.capacity > 0 && l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+	}
+}
+
+// Delete removes the value of key
+func (l *LRUCache) Delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.entries[key]; ok {
+		l.remove(el)
+	}
+}
+
+// Len returns the number of entries, including expired ones not yet removed
+func (l *LRUCache) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRUCache) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.entries, el.Value.(*lruEntry).key)
+}
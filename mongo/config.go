@@ -2,24 +2,75 @@ package mongo
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
 const (
-	localMongoURL = "mongodb://localhost:27017"
+	localMongoURL        = "mongodb://localhost:27017"
+	writeConcernMajority = "majority"
+	defaultPingBackoff   = time.Second
 )
 
+var validReadConcerns = map[string]bool{
+	"local":        true,
+	"available":    true,
+	"majority":     true,
+	"linearizable": true,
+	"snapshot":     true,
+}
+
 // DBConfig configures mongoDB
+// Zero values leave the driver defaults, or the values set in DBURL, untouched
 type DBConfig struct {
-	DBURL  string
-	DBName string
+	DBURL   string
+	DBName  string
+	AppName string
+
+	MaxPoolSize     uint64
+	MinPoolSize     uint64
+	MaxConnIdleTime time.Duration
+
+	// ReadPreference is one of primary, primaryPreferred, secondary, secondaryPreferred or nearest
+	ReadPreference string
+	// ReadConcern is one of local, available, majority, linearizable or snapshot
+	ReadConcern string
+	// WriteConcern is either majority or the number of nodes that must acknowledge writes
+	WriteConcern string
+	RetryWrites  *bool
+
+	TLS bool
+	// TLSCAFile is the PEM file of the certificate authorities trusted to sign the server certificate
+	TLSCAFile string
+	// TLSCertKeyFile is the PEM file holding the client certificate and its private key
+	TLSCertKeyFile        string
+	TLSInsecureSkipVerify bool
+
+	// PingRetries is how many more times the startup ping is attempted when it fails
+	PingRetries int
+	// PingBackoff is the wait before the first ping retry, doubling on each retry. It defaults to 1 second
+	PingBackoff time.Duration
+
+	// SlowQueryThreshold logs the commands that take longer, see NewQueryMonitor
+	SlowQueryThreshold time.Duration
+	// LogQueries logs every command with its collection, operation, duration and redacted filter
+	LogQueries bool
+	// QueryMetrics records every command e.g NewQueryStats()
+	QueryMetrics QueryMetrics
 }
 
 // DBProviderFunc provides the functionality of retuning a mongoDB database
@@ -28,6 +79,10 @@ type DBProviderFunc func() *mongo.Database
 // CloseMongoFunc provides the functionality of closing mongoDB connection
 type CloseMongoFunc func()
 
+// DisconnectFunc disconnects from mongoDB, waiting for operations in progress until ctx is done, and reports failures
+// It has the signature of a lifecycle.Closer, so can be registered as a shutdown step
+type DisconnectFunc func(ctx context.Context) error
+
 // DBProvider retuens a new mongoDB database
 func DBProvider(c *mongo.Client, dbname string) DBProviderFunc {
 	return func() *mongo.Database {
@@ -37,25 +92,298 @@ func DBProvider(c *mongo.Client, dbname string) DBProviderFunc {
 
 // ToProvider returns a mongoDB provider from the config
 func (c DBConfig) ToProvider() (DBProviderFunc, CloseMongoFunc, error) {
+	mClient, err := c.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	return DBProvider(mClient, c.DBName), DisconnectMongo(context.Background(), mClient), nil
+}
+
+// ToProviderWithDisconnect returns a mongoDB provider from the config, along with a disconnect taking a context
+// and returning its error, e.g to register with a lifecycle.Manager
+func (c DBConfig) ToProviderWithDisconnect() (DBProviderFunc, DisconnectFunc, error) {
+	mClient, err := c.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	return DBProvider(mClient, c.DBName), disconnectFunc(mClient), nil
+}
+
+// connect connects a client with the config and checks the server can be reached
+func (c DBConfig) connect() (*mongo.Client, error) {
+
+	opts, err := c.clientOptions()
+	if err != nil {
+		return nil, err
+	}
 
 	timeout := getTimeout()
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	defer cancel()
 
-	mClient, err := mongo.Connect(ctx, options.Client().ApplyURI(c.DBURL))
+	mClient, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "Unable to connect to mongo using config=%+v", c)
+		return nil, errors.Wrapf(err, "Unable to connect to mongo using config=%+v", c)
+	}
+	if err := c.ping(mClient, time.Duration(timeout)*time.Second); err != nil {
+		mClient.Disconnect(context.Background())
+		return nil, errors.Wrapf(err, "Unable to ping mongo using config=%+v", c)
+	}
+	return mClient, nil
+}
+
+// ping checks the server can be reached, retrying with an exponential backoff
+func (c DBConfig) ping(client *mongo.Client, timeout time.Duration) error {
+	backoff := c.PingBackoff
+	if backoff <= 0 {
+		backoff = defaultPingBackoff
+	}
+
+	var err error
+	for attempt := 0; attempt <= c.PingRetries; attempt++ {
+		if attempt > 0 {
+			log.Printf("Mongo ping failed, retrying in %s with error: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err = client.Ping(ctx, nil)
+		cancel()
+		if err == nil {
+			return nil
+		}
 	}
-	return DBProvider(mClient, c.DBName), DisconnectMongo(ctx, mClient), nil
+	return err
 }
 
 // NewConfigFromEnvVar returns mongo configuration from environment variables
+// Invalid values are logged and ignored, see LoadConfigFromEnvVar to get them as an error
 func NewConfigFromEnvVar() DBConfig {
-	return DBConfig{
-		DBURL:  os.Getenv("MONGO_URL"),
-		DBName: os.Getenv("MONGO_DB_NAME"),
+	c, problems := configFromEnvVar()
+	for _, p := range problems {
+		log.Println("Invalid mongo configuration: ", p)
+	}
+	return c
+}
+
+// LoadConfigFromEnvVar returns mongo configuration from environment variables, validated
+func LoadConfigFromEnvVar() (DBConfig, error) {
+	c, problems := configFromEnvVar()
+	if len(problems) > 0 {
+		return DBConfig{}, invalidConfigError(problems)
+	}
+	return c, c.Validate()
+}
+
+// Validate checks that the configuration values are consistent and supported
+func (c DBConfig) Validate() error {
+	problems := []string{}
+	if c.DBURL == "" {
+		problems = append(problems, "database url (MONGO_URL) is required")
+	}
+	if c.DBName == "" {
+		problems = append(problems, "database name (MONGO_DB_NAME) is required")
+	}
+	if c.MaxPoolSize > 0 && c.MinPoolSize > c.MaxPoolSize {
+		problems = append(problems, fmt.Sprintf("min pool size %d is greater than max pool size %d", c.MinPoolSize, c.MaxPoolSize))
+	}
+	if c.MaxConnIdleTime < 0 {
+		problems = append(problems, "max connection idle time can not be negative")
+	}
+	if c.SlowQueryThreshold < 0 {
+		problems = append(problems, "slow query threshold can not be negative")
+	}
+	if c.ReadPreference != "" {
+		if _, err := readpref.ModeFromString(c.ReadPreference); err != nil {
+			problems = append(problems, fmt.Sprintf("read preference %q is not one of primary, primaryPreferred, secondary, secondaryPreferred or nearest", c.ReadPreference))
+		}
+	}
+	if c.ReadConcern != "" && !validReadConcerns[c.ReadConcern] {
+		problems = append(problems, fmt.Sprintf("read concern %q is not one of local, available, majority, linearizable or snapshot", c.ReadConcern))
+	}
+	if c.WriteConcern != "" && c.WriteConcern != writeConcernMajority {
+		if w, err := strconv.Atoi(c.WriteConcern); err != nil || w < 0 {
+			problems = append(problems, fmt.Sprintf("write concern %q is neither majority nor a number of nodes", c.WriteConcern))
+		}
+	}
+	for _, f := range []string{c.TLSCAFile, c.TLSCertKeyFile} {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err != nil {
+			problems = append(problems, fmt.Sprintf("TLS file %s can not be read: %v", f, err))
+		}
+	}
+	if len(problems) > 0 {
+		return invalidConfigError(problems)
+	}
+	return nil
+}
+
+// clientOptions returns the driver options for the configuration
+func (c DBConfig) clientOptions() (*options.ClientOptions, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts := options.Client().ApplyURI(c.DBURL)
+	if c.AppName != "" {
+		opts.SetAppName(c.AppName)
 	}
+	if c.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(c.MaxPoolSize)
+	}
+	if c.MinPoolSize > 0 {
+		opts.SetMinPoolSize(c.MinPoolSize)
+	}
+	if c.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(c.MaxConnIdleTime)
+	}
+	if c.ReadPreference != "" {
+		mode, _ := readpref.ModeFromString(c.ReadPreference)
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid mongo read preference")
+		}
+		opts.SetReadPreference(rp)
+	}
+	if c.ReadConcern != "" {
+		opts.SetReadConcern(readconcern.New(readconcern.Level(c.ReadConcern)))
+	}
+	if c.WriteConcern == writeConcernMajority {
+		opts.SetWriteConcern(writeconcern.New(writeconcern.WMajority()))
+	} else if c.WriteConcern != "" {
+		w, _ := strconv.Atoi(c.WriteConcern)
+		opts.SetWriteConcern(writeconcern.New(writeconcern.W(w)))
+	}
+	if c.RetryWrites != nil {
+		opts.SetRetryWrites(*c.RetryWrites)
+	}
+	if c.TLS || c.TLSCAFile != "" || c.TLSCertKeyFile != "" {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	if c.LogQueries || c.SlowQueryThreshold > 0 || c.QueryMetrics != nil {
+		opts.SetMonitor(NewQueryMonitor(QueryMonitorOptions{
+			SlowThreshold: c.SlowQueryThreshold,
+			LogAll:        c.LogQueries,
+			Metrics:       c.QueryMetrics,
+		}))
+	}
+	return opts, nil
+}
+
+func (c DBConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: c.TLSInsecureSkipVerify}
+	if c.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(c.TLSCAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read mongo TLS CA file %s", c.TLSCAFile)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("mongo TLS CA file %s holds no PEM certificates", c.TLSCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.TLSCertKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertKeyFile, c.TLSCertKeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to load mongo TLS certificate and key from %s", c.TLSCertKeyFile)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// configFromEnvVar reads the configuration from environment variables, returning the values it could not parse
+func configFromEnvVar() (DBConfig, []string) {
+	problems := []string{}
+	c := DBConfig{
+		DBURL:          os.Getenv("MONGO_URL"),
+		DBName:         os.Getenv("MONGO_DB_NAME"),
+		AppName:        os.Getenv("MONGO_APP_NAME"),
+		ReadPreference: os.Getenv("MONGO_READ_PREFERENCE"),
+		ReadConcern:    os.Getenv("MONGO_READ_CONCERN"),
+		WriteConcern:   os.Getenv("MONGO_WRITE_CONCERN"),
+		TLSCAFile:      os.Getenv("MONGO_TLS_CA_FILE"),
+		TLSCertKeyFile: os.Getenv("MONGO_TLS_CERT_KEY_FILE"),
+	}
+
+	parseUint := func(key string, dst *uint64) {
+		if v := os.Getenv(key); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s=%q is not a positive number", key, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	parseBool := func(key string, dst *bool) bool {
+		if v := os.Getenv(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s=%q is not a boolean", key, v))
+				return false
+			}
+			*dst = b
+			return true
+		}
+		return false
+	}
+
+	parseUint("MONGO_MAX_POOL_SIZE", &c.MaxPoolSize)
+	parseUint("MONGO_MIN_POOL_SIZE", &c.MinPoolSize)
+	if v := os.Getenv("MONGO_MAX_CONN_IDLE_TIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("MONGO_MAX_CONN_IDLE_TIME=%q is not a duration e.g 30s", v))
+		} else {
+			c.MaxConnIdleTime = d
+		}
+	}
+	var retryWrites bool
+	if parseBool("MONGO_RETRY_WRITES", &retryWrites) {
+		c.RetryWrites = &retryWrites
+	}
+	if v := os.Getenv("MONGO_PING_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			problems = append(problems, fmt.Sprintf("MONGO_PING_RETRIES=%q is not a positive number", v))
+		} else {
+			c.PingRetries = n
+		}
+	}
+	if v := os.Getenv("MONGO_PING_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("MONGO_PING_BACKOFF=%q is not a duration e.g 1s", v))
+		} else {
+			c.PingBackoff = d
+		}
+	}
+	if v := os.Getenv("MONGO_SLOW_QUERY_THRESHOLD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("MONGO_SLOW_QUERY_THRESHOLD=%q is not a duration e.g 200ms", v))
+		} else {
+			c.SlowQueryThreshold = d
+		}
+	}
+	parseBool("MONGO_LOG_QUERIES", &c.LogQueries)
+	parseBool("MONGO_TLS", &c.TLS)
+	parseBool("MONGO_TLS_INSECURE", &c.TLSInsecureSkipVerify)
+
+	return c, problems
+}
+
+func invalidConfigError(problems []string) error {
+	return fmt.Errorf("invalid mongo config: %s", strings.Join(problems, "; "))
 }
 
 // NewLocalConfig returns mongo configuration for local testing
@@ -66,10 +394,11 @@ func NewLocalConfig(dbName string) DBConfig {
 	}
 }
 
-// DisconnectMongo disconnects the mongo after a specific timeout embedded in the context
+// DisconnectMongo returns a function disconnecting the client, waiting at most MONGO_TIMEOUT seconds for operations in progress
+// The timeout starts when the function is called, and ctx can cancel the disconnect sooner
 func DisconnectMongo(ctx context.Context, client *mongo.Client) CloseMongoFunc {
 	return func() {
-		if err := client.Disconnect(ctx); err != nil {
+		if err := DisconnectMongoContext(ctx, client); err != nil {
 			log.Println("Unable to disconnect mongo with error: ", err)
 			return
 		}
@@ -77,6 +406,19 @@ func DisconnectMongo(ctx context.Context, client *mongo.Client) CloseMongoFunc {
 	}
 }
 
+// DisconnectMongoContext disconnects the client, waiting at most MONGO_TIMEOUT seconds or until ctx is done
+func DisconnectMongoContext(ctx context.Context, client *mongo.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(getTimeout())*time.Second)
+	defer cancel()
+	return client.Disconnect(ctx)
+}
+
+func disconnectFunc(client *mongo.Client) DisconnectFunc {
+	return func(ctx context.Context) error {
+		return DisconnectMongoContext(ctx, client)
+	}
+}
+
 func getTimeout() int64 {
 
 	t := os.Getenv("MONGO_TIMEOUT")
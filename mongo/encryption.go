@@ -0,0 +1,473 @@
+package mongo
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	encryptTag              = "encrypt"
+	encryptedSubtype        = byte(0x80)
+	encryptedFormatVersion  = byte(1)
+	encryptionKeySize       = 32
+	encryptionKeysEnvVar    = "MONGO_ENCRYPTION_KEYS"
+	activeEncryptionKeyVar  = "MONGO_ENCRYPTION_ACTIVE_KEY"
+	deterministicTagValue   = "deterministic"
+	encryptionKeyInfoCipher = "mongo field encryption"
+	encryptionKeyInfoMAC    = "mongo field encryption iv"
+)
+
+// EncryptionMode is how a field is encrypted
+type EncryptionMode byte
+
+const (
+	// Randomized encrypts a value differently every time. Randomized fields can not be queried
+	Randomized EncryptionMode = 1
+	// Deterministic encrypts a value the same way every time, so equality and $in filters on the field still match
+	Deterministic EncryptionMode = 2
+)
+
+// encryptionKey holds the keys derived from a master key
+type encryptionKey struct {
+	aead   cipher.AEAD
+	macKey []byte
+}
+
+// Encryptor encrypts document fields with AES-256-GCM
+// Values are encrypted with the active key and decrypted with the key they were encrypted with,
+// so keys can be rotated by adding a new active key and calling Collection.RotateEncryption
+type Encryptor struct {
+	activeKeyID string
+	keys        map[string]encryptionKey
+}
+
+// NewEncryptor creates an encryptor from 32 byte master keys, keyed by id. IDs are stored with each value so must be kept short
+func NewEncryptor(activeKeyID string, keys map[string][]byte) (*Encryptor, error) {
+	if _, ok := keys[activeKeyID]; !ok {
+		return nil, fmt.Errorf("active encryption key %q is not one of the keys", activeKeyID)
+	}
+	e := &Encryptor{activeKeyID: activeKeyID, keys: map[string]encryptionKey{}}
+	for id, master := range keys {
+		if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("encryption key id %q must be 1 to 255 bytes long", id)
+		}
+		if len(master) != encryptionKeySize {
+			return nil, fmt.Errorf("encryption key %q must be %d bytes long, got %d", id, encryptionKeySize, len(master))
+		}
+		block, err := aes.NewCipher(deriveKey(master, encryptionKeyInfoCipher))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create cipher for encryption key %q", id)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to create cipher for encryption key %q", id)
+		}
+		e.keys[id] = encryptionKey{aead: aead, macKey: deriveKey(master, encryptionKeyInfoMAC)}
+	}
+	return e, nil
+}
+
+// NewEncryptorFromEnvVar creates an encryptor from MONGO_ENCRYPTION_KEYS, a comma separated list of id:base64key,
+// and MONGO_ENCRYPTION_ACTIVE_KEY, the id of the key new values are encrypted with
+func NewEncryptorFromEnvVar() (*Encryptor, error) {
+	keys := map[string][]byte{}
+	for _, pair := range strings.Split(os.Getenv(encryptionKeysEnvVar), ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s entries must be formatted as id:base64key", encryptionKeysEnvVar)
+		}
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "encryption key %q is not valid base64", parts[0])
+		}
+		keys[parts[0]] = key
+	}
+	return NewEncryptor(os.Getenv(activeEncryptionKeyVar), keys)
+}
+
+// fieldEncryption holds the encryptor of a collection and the fields it encrypts
+type fieldEncryption struct {
+	encryptor *Encryptor
+	fields    map[string]EncryptionMode
+}
+
+// WithEncryption returns a copy of the collection that encrypts the fields of model tagged with encrypt
+// e.g `bson:"phone" encrypt:"deterministic"` or `bson:"deviceToken" encrypt:"random"`.
+// Fields are encrypted on insert, replace and update, and decrypted by the finders. Only top level fields can be encrypted.
+// Filters on deterministic fields are encrypted, in Aggregate only those of the $match stages starting the pipeline
+func (c Collection) WithEncryption(e *Encryptor, model interface{}) Collection {
+	c.enc = &fieldEncryption{encryptor: e, fields: encryptedFields(model)}
+	return c
+}
+
+// RotateEncryption re-encrypts the values not encrypted with the active key and returns how many documents were updated
+// Only those values are set, and only while they are unchanged, so a document written during the rotation keeps the
+// write and is left to a later rotation. The version of rotated documents is bumped when versioning is on
+func (c Collection) RotateEncryption() (int, error) {
+	if c.enc == nil {
+		return 0, nil
+	}
+	ctx := c.getContext()
+	cur, err := c.col.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	err = eachDoc(ctx, cur, func(cur *mongo.Cursor) error {
+		filter, set, err := c.enc.rotation(cur.Current)
+		if err != nil || len(set) == 0 {
+			return err
+		}
+		res, err := c.col.UpdateOne(ctx, filter, c.updateDoc(set))
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount > 0 {
+			rotated++
+		}
+		return nil
+	})
+	return rotated, err
+}
+
+// rotation returns the values of a document to re-encrypt with the active key, and the filter matching the document
+// while it still holds the values read. Both are empty when every value is encrypted with the active key
+func (fe *fieldEncryption) rotation(raw bson.Raw) (bson.D, bson.D, error) {
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, nil, err
+	}
+	filter := bson.D{}
+	set := bson.D{}
+	for _, e := range d {
+		b, ok := e.Value.(primitive.Binary)
+		if !ok || b.Subtype != encryptedSubtype || keyIDOf(b.Data) == fe.encryptor.activeKeyID {
+			continue
+		}
+		v, err := fe.encryptor.decrypt(b.Data)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to decrypt field %s", e.Key)
+		}
+		rotated, err := fe.encryptor.encrypt(v, EncryptionMode(b.Data[1]), fe.encryptor.activeKeyID)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "unable to encrypt field %s", e.Key)
+		}
+		filter = append(filter, bson.E{Key: e.Key, Value: b})
+		set = append(set, bson.E{Key: e.Key, Value: rotated})
+	}
+	if len(set) == 0 {
+		return nil, nil, nil
+	}
+	id, _ := lookupField(d, "_id")
+	return append(bson.D{{Key: "_id", Value: id}}, filter...), set, nil
+}
+
+// encryptedFields returns the encryption mode of the tagged fields of a struct, keyed by bson field name
+func encryptedFields(model interface{}) map[string]EncryptionMode {
+	fields := map[string]EncryptionMode{}
+	t := reflect.TypeOf(model)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fields
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup(encryptTag)
+		if !ok || tag == "-" {
+			continue
+		}
+		name := strings.ToLower(f.Name)
+		if bsonName := strings.Split(f.Tag.Get("bson"), ",")[0]; bsonName != "" && bsonName != "-" {
+			name = bsonName
+		}
+		mode := Randomized
+		if tag == deterministicTagValue {
+			mode = Deterministic
+		}
+		fields[name] = mode
+	}
+	return fields
+}
+
+// encryptDoc encrypts the encrypted fields of a document
+func (fe *fieldEncryption) encryptDoc(doc interface{}) (bson.D, error) {
+	d, err := withFields(doc)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range d {
+		mode, ok := fe.fields[e.Key]
+		if !ok || e.Value == nil || isEncrypted(e.Value) {
+			continue
+		}
+		v, err := fe.encryptor.encrypt(e.Value, mode, fe.encryptor.activeKeyID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to encrypt field %s", e.Key)
+		}
+		d[i].Value = v
+	}
+	return d, nil
+}
+
+// decryptDoc decrypts every encrypted value at the top level of a document
+func (fe *fieldEncryption) decryptDoc(raw bson.Raw) (bson.D, error) {
+	var d bson.D
+	if err := bson.Unmarshal(raw, &d); err != nil {
+		return nil, err
+	}
+	for i, e := range d {
+		b, ok := e.Value.(primitive.Binary)
+		if !ok || b.Subtype != encryptedSubtype {
+			continue
+		}
+		v, err := fe.encryptor.decrypt(b.Data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to decrypt field %s", e.Key)
+		}
+		d[i].Value = v
+	}
+	return d, nil
+}
+
+// encryptFilter encrypts the values deterministic fields are compared with in equality and $in conditions
+// Values are encrypted with every key, so documents not yet rotated to the active key still match
+func (fe *fieldEncryption) encryptFilter(filter interface{}) (interface{}, error) {
+	f, err := withFields(filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range f {
+		switch {
+		case e.Key == "$and" || e.Key == "$or":
+			clauses, _ := e.Value.(bson.A)
+			encrypted := bson.A{}
+			for _, clause := range clauses {
+				ec, err := fe.encryptFilter(clause)
+				if err != nil {
+					return nil, err
+				}
+				encrypted = append(encrypted, ec)
+			}
+			f[i].Value = encrypted
+		case fe.fields[e.Key] == Deterministic:
+			cond, err := fe.encryptCondition(e.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to encrypt filter on field %s", e.Key)
+			}
+			f[i].Value = cond
+		}
+	}
+	return f, nil
+}
+
+func (fe *fieldEncryption) encryptCondition(cond interface{}) (interface{}, error) {
+	ops, isOps := cond.(bson.D)
+	if !isOps || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		values, err := fe.encryptWithAllKeys(cond)
+		if err != nil {
+			return nil, err
+		}
+		return bson.D{{Key: "$in", Value: values}}, nil
+	}
+
+	out := bson.D{}
+	for _, op := range ops {
+		switch op.Key {
+		case "$eq", "$ne":
+			values, err := fe.encryptWithAllKeys(op.Value)
+			if err != nil {
+				return nil, err
+			}
+			key := "$in"
+			if op.Key == "$ne" {
+				key = "$nin"
+			}
+			out = append(out, bson.E{Key: key, Value: values})
+		case "$in", "$nin":
+			list, _ := op.Value.(bson.A)
+			values := bson.A{}
+			for _, v := range list {
+				encrypted, err := fe.encryptWithAllKeys(v)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, encrypted...)
+			}
+			out = append(out, bson.E{Key: op.Key, Value: values})
+		default:
+			// range and pattern operators can not match encrypted values and are passed on as they are
+			out = append(out, op)
+		}
+	}
+	return out, nil
+}
+
+func (fe *fieldEncryption) encryptWithAllKeys(v interface{}) (bson.A, error) {
+	values := bson.A{}
+	for id := range fe.encryptor.keys {
+		encrypted, err := fe.encryptor.encrypt(v, Deterministic, id)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encrypted)
+	}
+	return values, nil
+}
+
+// encrypt encrypts a value into a binary holding: format version, mode, key id length, key id, nonce and ciphertext
+func (e *Encryptor) encrypt(v interface{}, mode EncryptionMode, keyID string) (primitive.Binary, error) {
+	t, data, err := bson.MarshalValue(v)
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+	plaintext := append([]byte{byte(t)}, data...)
+
+	key := e.keys[keyID]
+	header := append([]byte{encryptedFormatVersion, byte(mode), byte(len(keyID))}, keyID...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if mode == Deterministic {
+		mac := hmac.New(sha256.New, key.macKey)
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return primitive.Binary{}, err
+	}
+
+	out := append(header, nonce...)
+	out = key.aead.Seal(out, nonce, plaintext, header)
+	return primitive.Binary{Subtype: encryptedSubtype, Data: out}, nil
+}
+
+// decrypt decrypts a binary produced by encrypt back into its bson value
+func (e *Encryptor) decrypt(data []byte) (bson.RawValue, error) {
+	if len(data) < 3 || data[0] != encryptedFormatVersion {
+		return bson.RawValue{}, errors.New("unknown encrypted value format")
+	}
+	idLen := int(data[2])
+	if len(data) < 3+idLen {
+		return bson.RawValue{}, errors.New("encrypted value is truncated")
+	}
+	keyID := keyIDOf(data)
+	key, ok := e.keys[keyID]
+	if !ok {
+		return bson.RawValue{}, fmt.Errorf("encryption key %q is unknown", keyID)
+	}
+
+	header := data[:3+idLen]
+	rest := data[3+idLen:]
+	if len(rest) < key.aead.NonceSize() {
+		return bson.RawValue{}, errors.New("encrypted value is truncated")
+	}
+	plaintext, err := key.aead.Open(nil, rest[:key.aead.NonceSize()], rest[key.aead.NonceSize():], header)
+	if err != nil {
+		return bson.RawValue{}, err
+	}
+	if len(plaintext) == 0 {
+		return bson.RawValue{}, errors.New("encrypted value is empty")
+	}
+	return bson.RawValue{Type: bsontype.Type(plaintext[0]), Value: plaintext[1:]}, nil
+}
+
+// keyIDOf returns the id of the key a binary produced by encrypt was encrypted with, empty when it is malformed
+func keyIDOf(data []byte) string {
+	if len(data) < 3 || len(data) < 3+int(data[2]) {
+		return ""
+	}
+	return string(data[3 : 3+int(data[2])])
+}
+
+func isEncrypted(v interface{}) bool {
+	b, ok := v.(primitive.Binary)
+	return ok && b.Subtype == encryptedSubtype
+}
+
+func deriveKey(master []byte, info string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(info))
+	return mac.Sum(nil)
+}
+
+// encryptFields encrypts the encrypted fields of a document about to be written
+func (c Collection) encryptFields(doc interface{}) (interface{}, error) {
+	if c.enc == nil {
+		return doc, nil
+	}
+	return c.enc.encryptDoc(doc)
+}
+
+// decodeOne decodes the result of a FindOne, decrypting its encrypted fields
+func (c Collection) decodeOne(res *mongo.SingleResult, r interface{}) error {
+	if c.enc == nil {
+		return res.Decode(r)
+	}
+	raw, err := res.Raw()
+	if err != nil {
+		return err
+	}
+	return c.decodeRaw(raw, r)
+}
+
+// decodeRaw decodes a document, decrypting its encrypted fields
+func (c Collection) decodeRaw(raw bson.Raw, r interface{}) error {
+	if c.enc == nil {
+		return bson.Unmarshal(raw, r)
+	}
+	d, err := c.enc.decryptDoc(raw)
+	if err != nil {
+		return err
+	}
+	return decodeDoc(d, r)
+}
+
+// decrypting wraps 'onEach' so the cursor it is handed holds the decrypted document
+func (c Collection) decrypting(onEach func(c *mongo.Cursor) error) func(c *mongo.Cursor) error {
+	if c.enc == nil {
+		return onEach
+	}
+	return func(cur *mongo.Cursor) error {
+		d, err := c.enc.decryptDoc(cur.Current)
+		if err != nil {
+			return err
+		}
+		one, err := mongo.NewCursorFromDocuments([]interface{}{d}, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer one.Close(context.Background())
+		one.Next(context.Background())
+		return onEach(one)
+	}
+}
+
+// encryptScope encrypts the deterministic values of a filter. A filter that can not be encrypted is not sent,
+// as it would carry the plaintext of encrypted fields
+func (c Collection) encryptScope(filter interface{}) (interface{}, error) {
+	if c.enc == nil {
+		return filter, nil
+	}
+	return c.enc.encryptFilter(filter)
+}
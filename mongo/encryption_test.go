@@ -0,0 +1,177 @@
+package mongo
+
+import (
+	"bytes"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type encryptedPerson struct {
+	Name  string `bson:"name"`
+	Phone string `bson:"phone" encrypt:"deterministic"`
+	Token string `bson:"token" encrypt:"random"`
+}
+
+func testEncryptor(t *testing.T, activeKeyID string) *Encryptor {
+	t.Helper()
+	e, err := NewEncryptor(activeKeyID, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, encryptionKeySize),
+		"k2": bytes.Repeat([]byte{2}, encryptionKeySize),
+	})
+	if err != nil {
+		t.Fatalf("Unable to create encryptor: %v", err)
+	}
+	return e
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	e := testEncryptor(t, "k1")
+	for _, mode := range []EncryptionMode{Randomized, Deterministic} {
+		for _, v := range []interface{}{"+2348000000000", int64(42), true, bson.D{{Key: "a", Value: "b"}}} {
+			b, err := e.encrypt(v, mode, "k1")
+			if err != nil {
+				t.Fatalf("Unable to encrypt %v: %v", v, err)
+			}
+			if b.Subtype != encryptedSubtype || keyIDOf(b.Data) != "k1" {
+				t.Errorf("Encrypted %v has subtype %x and key %q", v, b.Subtype, keyIDOf(b.Data))
+			}
+			got, err := e.decrypt(b.Data)
+			if err != nil {
+				t.Fatalf("Unable to decrypt %v: %v", v, err)
+			}
+			wantType, want, _ := bson.MarshalValue(v)
+			if got.Type != wantType || !bytes.Equal(got.Value, want) {
+				t.Errorf("Decrypted %v as %v", v, got)
+			}
+		}
+	}
+}
+
+func TestEncryptModes(t *testing.T) {
+	e := testEncryptor(t, "k1")
+	d1, _ := e.encrypt("secret", Deterministic, "k1")
+	d2, _ := e.encrypt("secret", Deterministic, "k1")
+	if !bytes.Equal(d1.Data, d2.Data) {
+		t.Error("Deterministic encryption of the same value differs")
+	}
+	d3, _ := e.encrypt("secret", Deterministic, "k2")
+	if bytes.Equal(d1.Data, d3.Data) {
+		t.Error("Deterministic encryption with different keys is the same")
+	}
+	r1, _ := e.encrypt("secret", Randomized, "k1")
+	r2, _ := e.encrypt("secret", Randomized, "k1")
+	if bytes.Equal(r1.Data, r2.Data) {
+		t.Error("Randomized encryption of the same value is the same")
+	}
+}
+
+func TestDecryptAfterKeyRotation(t *testing.T) {
+	old, _ := testEncryptor(t, "k1").encrypt("secret", Randomized, "k1")
+	got, err := testEncryptor(t, "k2").decrypt(old.Data)
+	if err != nil {
+		t.Fatalf("Unable to decrypt a value of the previous key: %v", err)
+	}
+	if got.StringValue() != "secret" {
+		t.Errorf("Decrypted %v, want secret", got)
+	}
+
+	fe := &fieldEncryption{encryptor: testEncryptor(t, "k2"), fields: encryptedFields(encryptedPerson{})}
+	raw, _ := bson.Marshal(bson.D{{Key: "_id", Value: "1"}, {Key: "token", Value: old}})
+	filter, set, err := fe.rotation(raw)
+	if err != nil {
+		t.Fatalf("Unable to rotate: %v", err)
+	}
+	if len(set) != 1 || keyIDOf(set[0].Value.(primitive.Binary).Data) != "k2" {
+		t.Errorf("Rotation sets %v, want token encrypted with k2", set)
+	}
+	if len(filter) != 2 || filter[1].Key != "token" || !bytes.Equal(filter[1].Value.(primitive.Binary).Data, old.Data) {
+		t.Errorf("Rotation filter is %v, want _id and the token read", filter)
+	}
+
+	rotated, _ := bson.Marshal(bson.D{{Key: "_id", Value: "1"}, {Key: "token", Value: set[0].Value}})
+	if _, set, _ := fe.rotation(rotated); len(set) != 0 {
+		t.Errorf("Rotation of a rotated document sets %v", set)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	e := testEncryptor(t, "k1")
+	b, _ := e.encrypt("secret", Randomized, "k1")
+	b.Data[len(b.Data)-1] ^= 1
+	if _, err := e.decrypt(b.Data); err == nil {
+		t.Error("Decrypted a tampered value")
+	}
+	b, _ = e.encrypt("secret", Randomized, "k1")
+	b.Data[1] = byte(Deterministic)
+	if _, err := e.decrypt(b.Data); err == nil {
+		t.Error("Decrypted a value with a tampered header")
+	}
+}
+
+func TestEncryptFilter(t *testing.T) {
+	fe := &fieldEncryption{encryptor: testEncryptor(t, "k1"), fields: encryptedFields(encryptedPerson{})}
+	f, err := fe.encryptFilter(bson.M{"phone": "+2348000000000"})
+	if err != nil {
+		t.Fatalf("Unable to encrypt filter: %v", err)
+	}
+	cond := f.(bson.D)[0].Value.(bson.D)
+	if cond[0].Key != "$in" || len(cond[0].Value.(bson.A)) != 2 {
+		t.Fatalf("Equality filter is %v, want $in of a value per key", cond)
+	}
+	want, _ := fe.encryptor.encrypt("+2348000000000", Deterministic, "k2")
+	found := false
+	for _, v := range cond[0].Value.(bson.A) {
+		found = found || bytes.Equal(v.(primitive.Binary).Data, want.Data)
+	}
+	if !found {
+		t.Error("Equality filter does not match values encrypted with a previous key")
+	}
+
+	f, _ = fe.encryptFilter(bson.D{
+		{Key: "name", Value: "ada"},
+		{Key: "$or", Value: bson.A{bson.D{{Key: "phone", Value: bson.D{{Key: "$ne", Value: "1"}}}}}},
+		{Key: "token", Value: "t"},
+	})
+	d := f.(bson.D)
+	if d[0].Value != "ada" || d[2].Value != "t" {
+		t.Errorf("Filter on unencrypted and randomized fields changed to %v", d)
+	}
+	or := d[1].Value.(bson.A)[0].(bson.D)[0].Value.(bson.D)
+	if or[0].Key != "$nin" || len(or[0].Value.(bson.A)) != 2 {
+		t.Errorf("$ne in $or is %v, want $nin of a value per key", or)
+	}
+}
+
+func TestUnencryptableFilterIsNotSent(t *testing.T) {
+	// the collection has no client, so the finders panic if they send the filter
+	c := Collection{}.WithEncryption(testEncryptor(t, "k1"), encryptedPerson{})
+	filter := bson.M{"phone": make(chan int)}
+	if err := c.FindByFilter(filter, &encryptedPerson{}); err == nil {
+		t.Error("FindByFilter succeeded with a filter that can not be encrypted")
+	}
+	if err := c.FindMultiWithFilter(filter, func(*mongo.Cursor) error { return nil }); err == nil {
+		t.Error("FindMultiWithFilter succeeded with a filter that can not be encrypted")
+	}
+}
+
+func TestEncryptLeadingMatches(t *testing.T) {
+	c := Collection{}.WithEncryption(testEncryptor(t, "k1"), encryptedPerson{})
+	p := NewPipeline().
+		Match(bson.M{"phone": "+2348000000000"}).
+		Group("$name", bson.M{"n": bson.M{"$sum": 1}}).
+		Match(bson.M{"phone": "+2348000000000"})
+	stages, err := c.encryptLeadingMatches(p.Stages())
+	if err != nil {
+		t.Fatalf("Unable to encrypt pipeline: %v", err)
+	}
+	first := stages[0][0].Value.(bson.D)[0].Value.(bson.D)
+	if first[0].Key != "$in" {
+		t.Errorf("Leading $match is %v, want the phone encrypted", first)
+	}
+	if last := stages[2][0].Value.(bson.M); last["phone"] != "+2348000000000" {
+		t.Errorf("$match after $group is %v, want it unchanged", last)
+	}
+}
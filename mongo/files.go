@@ -0,0 +1,241 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/babyfaceEasy/commons/httputils"
+	"github.com/babyfaceEasy/commons/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultFileBucket = "fs"
+	// contentSniffLength is how much of a stream is read to detect its content type
+	contentSniffLength = 3072
+)
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	ID          string    `json:"id"`
+	FileName    string    `json:"filename"`
+	ContentType string    `json:"contentType"`
+	UploadKey   string    `json:"uploadKey,omitempty"`
+	Length      int64     `json:"length"`
+	UploadedAt  time.Time `json:"uploadedAt"`
+}
+
+// fileMetadata is the metadata stored with each GridFS file
+type fileMetadata struct {
+	ContentType string `bson:"contentType"`
+	UploadKey   string `bson:"uploadKey,omitempty"`
+}
+
+// FileStore stores files in a GridFS bucket, chunked so they are never held in memory whole
+type FileStore struct {
+	provideDB DBProviderFunc
+	bucket    string
+	ctx       context.Context
+}
+
+// NewFileStore creates a file store in the GridFS bucket, "fs" when bucket is empty
+func NewFileStore(provideDB DBProviderFunc, bucket string) FileStore {
+	if bucket == "" {
+		bucket = defaultFileBucket
+	}
+	return FileStore{provideDB: provideDB, bucket: bucket}
+}
+
+// WithContext returns a copy of the store whose operations run with ctx
+func (s FileStore) WithContext(ctx context.Context) FileStore {
+	s.ctx = ctx
+	return s
+}
+
+// Save stores a file extracted by httputils.ExtractMultipleFileUploads or httputils.ExtractBodyAndFileUploads
+func (s FileStore) Save(fd httputils.FileDetails) (FileInfo, error) {
+	return s.save(fd.FileName, fd.ContentType, fd.UploadKey, bytes.NewReader(fd.Data))
+}
+
+// SaveStream stores the content of r. The content type is detected from the content when empty
+func (s FileStore) SaveStream(fileName, contentType string, r io.Reader) (FileInfo, error) {
+	return s.save(fileName, contentType, "", r)
+}
+
+func (s FileStore) save(fileName, contentType, uploadKey string, r io.Reader) (FileInfo, error) {
+	if contentType == "" {
+		head := make([]byte, contentSniffLength)
+		n, err := io.ReadFull(r, head)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return FileInfo{}, errors.Wrapf(err, "unable to read file %s", fileName)
+		}
+		head = head[:n]
+		contentType = httputils.GetFileContentType(bytes.NewReader(head))
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	b, err := s.openBucket()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	id := string(uuid.GenV4())
+	meta := fileMetadata{ContentType: contentType, UploadKey: uploadKey}
+	up, err := b.OpenUploadStreamWithID(id, fileName, options.GridFSUpload().SetMetadata(meta))
+	if err != nil {
+		return FileInfo{}, errors.Wrapf(err, "unable to store file %s", fileName)
+	}
+	n, err := io.Copy(up, r)
+	if err != nil {
+		up.Abort()
+		return FileInfo{}, errors.Wrapf(err, "unable to store file %s", fileName)
+	}
+	if err := up.Close(); err != nil {
+		return FileInfo{}, errors.Wrapf(err, "unable to store file %s", fileName)
+	}
+
+	return FileInfo{
+		ID:          id,
+		FileName:    fileName,
+		ContentType: contentType,
+		UploadKey:   uploadKey,
+		Length:      n,
+		UploadedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// Open returns the content of the file with the given id, which the caller must close
+// A missing file returns mongo.ErrNoDocuments, see IsNotFoundError
+func (s FileStore) Open(id string) (io.ReadCloser, FileInfo, error) {
+	b, err := s.openBucket()
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	down, err := b.OpenDownloadStream(id)
+	if err == gridfs.ErrFileNotFound {
+		return nil, FileInfo{}, mongo.ErrNoDocuments
+	}
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	info, err := toFileInfo(down.GetFile())
+	if err != nil {
+		down.Close()
+		return nil, FileInfo{}, err
+	}
+	return down, info, nil
+}
+
+// Stat returns the description of the file with the given id
+func (s FileStore) Stat(id string) (FileInfo, error) {
+	b, err := s.openBucket()
+	if err != nil {
+		return FileInfo{}, err
+	}
+	cur, err := b.FindContext(s.getContext(), bson.M{"_id": id})
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer cur.Close(s.getContext())
+
+	if !cur.Next(s.getContext()) {
+		if err := cur.Err(); err != nil {
+			return FileInfo{}, err
+		}
+		return FileInfo{}, mongo.ErrNoDocuments
+	}
+	var f gridfs.File
+	if err := cur.Decode(&f); err != nil {
+		return FileInfo{}, err
+	}
+	return toFileInfo(&f)
+}
+
+// Download reads the whole file with the given id, e.g to pass on to httputils.ServeFile
+func (s FileStore) Download(id string) (httputils.FileDetails, error) {
+	r, info, err := s.Open(id)
+	if err != nil {
+		return httputils.FileDetails{}, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return httputils.FileDetails{}, errors.Wrapf(err, "unable to read file %s", id)
+	}
+	return httputils.FileDetails{
+		UploadKey:   info.UploadKey,
+		FileName:    info.FileName,
+		Data:        data,
+		ContentType: info.ContentType,
+	}, nil
+}
+
+// Serve streams the file with the given id as an attachment, see httputils.ServeFileStream
+// Nothing is written when the file can not be opened, so the caller can still serve the error
+func (s FileStore) Serve(w http.ResponseWriter, id string) error {
+	r, info, err := s.Open(id)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return httputils.ServeFileStream(w, info.FileName, info.ContentType, info.Length, r)
+}
+
+// Delete removes the file with the given id and its content
+func (s FileStore) Delete(id string) error {
+	b, err := s.openBucket()
+	if err != nil {
+		return err
+	}
+	err = b.DeleteContext(s.getContext(), id)
+	if err == gridfs.ErrFileNotFound {
+		return mongo.ErrNoDocuments
+	}
+	return err
+}
+
+func (s FileStore) openBucket() (*gridfs.Bucket, error) {
+	b, err := gridfs.NewBucket(s.provideDB(), options.GridFSBucket().SetName(s.bucket))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open file bucket %s", s.bucket)
+	}
+	if deadline, ok := s.getContext().Deadline(); ok {
+		b.SetReadDeadline(deadline)
+		b.SetWriteDeadline(deadline)
+	}
+	return b, nil
+}
+
+// getContext returns the context operations on the store should run with
+func (s FileStore) getContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+func toFileInfo(f *gridfs.File) (FileInfo, error) {
+	var meta fileMetadata
+	if len(f.Metadata) > 0 {
+		if err := bson.Unmarshal(f.Metadata, &meta); err != nil {
+			return FileInfo{}, errors.Wrap(err, "unable to decode file metadata")
+		}
+	}
+	id, _ := f.ID.(string)
+	return FileInfo{
+		ID:          id,
+		FileName:    f.Name,
+		ContentType: meta.ContentType,
+		UploadKey:   meta.UploadKey,
+		Length:      f.Length,
+		UploadedAt:  f.UploadDate,
+	}, nil
+}
@@ -0,0 +1,29 @@
+package mongo
+
+import (
+	"context"
+	"time"
+)
+
+const defaultHealthCheckTimeout = 2 * time.Second
+
+// HealthChecker checks that mongo can be reached. It can be mounted by the httputils health handlers
+type HealthChecker struct {
+	provideDB DBProviderFunc
+	timeout   time.Duration
+}
+
+// NewHealthChecker creates a health checker whose pings time out after timeout, 2 seconds by default
+func NewHealthChecker(provideDB DBProviderFunc, timeout time.Duration) HealthChecker {
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	return HealthChecker{provideDB: provideDB, timeout: timeout}
+}
+
+// Check pings the mongo server
+func (h HealthChecker) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	return h.provideDB().Client().Ping(ctx, nil)
+}
@@ -0,0 +1,287 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	idIndexName      = "id_1"
+	builtinIndexName = "_id_"
+	textIndexType    = "text"
+)
+
+var (
+	indexRegistry   = map[string][]IndexSpec{}
+	indexRegistryMu sync.Mutex
+)
+
+// IndexSpec declares an index on a collection
+type IndexSpec struct {
+	// Name defaults to the name mongo would generate from the keys e.g "email_1_createdAt_-1"
+	Name string
+	// Keys are the indexed fields in order, with their index type (1, -1, "text", ...)
+	Keys bson.D
+	// Unique rejects documents with duplicate values for the keys
+	Unique bool
+	// Sparse only indexes documents that have the keys
+	Sparse bool
+	// Expires makes a TTL index, removing documents TTL after the time stored in the (single) key.
+	// A TTL of 0 removes documents once that time is reached, so the key holds an expiry date
+	Expires bool
+	TTL     time.Duration
+	// Partial only indexes documents that match this filter. Use a bson.D so the field order is stable
+	Partial interface{}
+}
+
+// IndexDrift describes a declared index that exists with different keys or options
+type IndexDrift struct {
+	Collection string `json:"collection"`
+	Index      string `json:"index"`
+	Reason     string `json:"reason"`
+}
+
+// IndexReport describes the outcome of EnsureIndexes
+type IndexReport struct {
+	// Created holds the indexes created, as collection.index
+	Created []string `json:"created"`
+	// Drifted holds the declared indexes that differ from the ones in the database. They are left untouched
+	Drifted []IndexDrift `json:"drifted"`
+	// Unmanaged holds the indexes in the database that are not declared, as collection.index
+	Unmanaged []string `json:"unmanaged"`
+}
+
+// IndexOn declares an ascending (compound) index on the fields
+func IndexOn(fields ...string) IndexSpec {
+	keys := bson.D{}
+	for _, f := range fields {
+		keys = append(keys, bson.E{Key: f, Value: int(Ascending)})
+	}
+	return IndexSpec{Keys: keys}
+}
+
+// UniqueIndexOn declares a unique ascending (compound) index on the fields
+func UniqueIndexOn(fields ...string) IndexSpec {
+	spec := IndexOn(fields...)
+	spec.Unique = true
+	return spec
+}
+
+// TTLIndexOn declares an index expiring documents ttl after the time stored in field, or at that time when ttl is 0
+func TTLIndexOn(field string, ttl time.Duration) IndexSpec {
+	spec := IndexOn(field)
+	spec.Expires = true
+	spec.TTL = ttl
+	return spec
+}
+
+// TextIndexOn declares a text index on the fields. A collection can have only one text index
+func TextIndexOn(fields ...string) IndexSpec {
+	keys := bson.D{}
+	for _, f := range fields {
+		keys = append(keys, bson.E{Key: f, Value: textIndexType})
+	}
+	return IndexSpec{Keys: keys}
+}
+
+// RegisterIndexes declares indexes for a collection, to be created by EnsureIndexes
+// A unique index on "id" is declared for every registered collection, unless a spec named "id_1" is registered
+func RegisterIndexes(collection string, specs ...IndexSpec) {
+	indexRegistryMu.Lock()
+	defer indexRegistryMu.Unlock()
+	indexRegistry[collection] = append(indexRegistry[collection], specs...)
+}
+
+// EnsureIndexes creates the registered indexes missing from the database and reports the ones that drifted
+func EnsureIndexes(ctx context.Context, provideDB DBProviderFunc) (IndexReport, error) {
+	indexRegistryMu.Lock()
+	registered := make(map[string][]IndexSpec, len(indexRegistry))
+	names := make([]string, 0, len(indexRegistry))
+	for cn, specs := range indexRegistry {
+		registered[cn] = append([]IndexSpec{}, specs...)
+		names = append(names, cn)
+	}
+	indexRegistryMu.Unlock()
+	sort.Strings(names)
+
+	db := provideDB()
+	report := IndexReport{}
+	for _, cn := range names {
+		if err := ensureCollectionIndexes(ctx, db.Collection(cn), withIDIndex(registered[cn]), &report); err != nil {
+			return report, errors.Wrapf(err, "unable to ensure indexes of collection=%s", cn)
+		}
+	}
+	return report, nil
+}
+
+func ensureCollectionIndexes(ctx context.Context, col *mongo.Collection, specs []IndexSpec, report *IndexReport) error {
+	existing, err := listIndexes(ctx, col)
+	if err != nil {
+		return err
+	}
+
+	declared := map[string]bool{builtinIndexName: true}
+	missing := []mongo.IndexModel{}
+	for _, spec := range specs {
+		name := spec.name()
+		declared[name] = true
+
+		idx, ok := existing[name]
+		if !ok {
+			missing = append(missing, spec.model())
+			continue
+		}
+		if reason := spec.drift(idx); reason != "" {
+			report.Drifted = append(report.Drifted, IndexDrift{Collection: col.Name(), Index: name, Reason: reason})
+		}
+	}
+
+	unmanaged := []string{}
+	for name := range existing {
+		if !declared[name] {
+			unmanaged = append(unmanaged, col.Name()+"."+name)
+		}
+	}
+	sort.Strings(unmanaged)
+	report.Unmanaged = append(report.Unmanaged, unmanaged...)
+
+	if len(missing) == 0 {
+		return nil
+	}
+	created, err := col.Indexes().CreateMany(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for _, name := range created {
+		report.Created = append(report.Created, col.Name()+"."+name)
+	}
+	return nil
+}
+
+// existingIndex is an index as listed by the database
+type existingIndex struct {
+	Name               string   `bson:"name"`
+	Key                bson.D   `bson:"key"`
+	Unique             bool     `bson:"unique"`
+	Sparse             bool     `bson:"sparse"`
+	ExpireAfterSeconds *int32   `bson:"expireAfterSeconds"`
+	Partial            bson.Raw `bson:"partialFilterExpression"`
+}
+
+func listIndexes(ctx context.Context, col *mongo.Collection) (map[string]existingIndex, error) {
+	cur, err := col.Indexes().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	indexes := map[string]existingIndex{}
+	for cur.Next(ctx) {
+		var idx existingIndex
+		if err := cur.Decode(&idx); err != nil {
+			return nil, err
+		}
+		indexes[idx.Name] = idx
+	}
+	return indexes, cur.Err()
+}
+
+func withIDIndex(specs []IndexSpec) []IndexSpec {
+	for _, spec := range specs {
+		if spec.name() == idIndexName {
+			return specs
+		}
+	}
+	return append([]IndexSpec{UniqueIndexOn("id")}, specs...)
+}
+
+func (s IndexSpec) name() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	parts := []string{}
+	for _, k := range s.Keys {
+		parts = append(parts, fmt.Sprintf("%s_%v", k.Key, k.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (s IndexSpec) isText() bool {
+	for _, k := range s.Keys {
+		if k.Value == textIndexType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s IndexSpec) model() mongo.IndexModel {
+	opts := options.Index().SetName(s.name())
+	if s.Unique {
+		opts.SetUnique(true)
+	}
+	if s.Sparse {
+		opts.SetSparse(true)
+	}
+	if s.Expires || s.TTL > 0 {
+		opts.SetExpireAfterSeconds(int32(s.TTL.Seconds()))
+	}
+	if s.Partial != nil {
+		opts.SetPartialFilterExpression(s.Partial)
+	}
+	return mongo.IndexModel{Keys: s.Keys, Options: opts}
+}
+
+// drift returns why an existing index differs from the spec, or an empty string when it matches
+func (s IndexSpec) drift(idx existingIndex) string {
+	// text indexes are stored with internal keys, so only their options are compared
+	if !s.isText() && fmt.Sprint(normaliseKeys(s.Keys)) != fmt.Sprint(normaliseKeys(idx.Key)) {
+		return fmt.Sprintf("keys are %v, expected %v", idx.Key, s.Keys)
+	}
+	if s.Unique != idx.Unique {
+		return fmt.Sprintf("unique is %t, expected %t", idx.Unique, s.Unique)
+	}
+	if s.Sparse != idx.Sparse {
+		return fmt.Sprintf("sparse is %t, expected %t", idx.Sparse, s.Sparse)
+	}
+
+	expires := s.Expires || s.TTL > 0
+	if expires != (idx.ExpireAfterSeconds != nil) {
+		return fmt.Sprintf("ttl is set %t, expected %t", idx.ExpireAfterSeconds != nil, expires)
+	}
+	if expires && int32(s.TTL.Seconds()) != *idx.ExpireAfterSeconds {
+		return fmt.Sprintf("ttl is %ds, expected %ds", *idx.ExpireAfterSeconds, int32(s.TTL.Seconds()))
+	}
+
+	var partial bson.Raw
+	if s.Partial != nil {
+		bb, err := bson.Marshal(s.Partial)
+		if err != nil {
+			return fmt.Sprintf("partial filter can not be compared: %v", err)
+		}
+		partial = bb
+	}
+	if !bytes.Equal(partial, idx.Partial) {
+		return fmt.Sprintf("partial filter is %v, expected %v", idx.Partial, partial)
+	}
+	return ""
+}
+
+// normaliseKeys formats the key values so numbers of different types compare equal
+func normaliseKeys(keys bson.D) []string {
+	out := []string{}
+	for _, k := range keys {
+		out = append(out, fmt.Sprintf("%s:%v", k.Key, k.Value))
+	}
+	return out
+}
@@ -0,0 +1,242 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/babyfaceEasy/commons/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	locksCollection      = "locks"
+	lockFencesCollection = "lock_fences"
+	defaultLockTTL       = 30 * time.Second
+)
+
+var (
+	// ErrLockHeld is returned when the lock is held by another owner
+	ErrLockHeld = errors.New("lock is held by another owner")
+	// ErrLockLost is returned when a lease expired and the lock was taken by another owner, or released
+	ErrLockLost = errors.New("lock is no longer held")
+)
+
+// Locker acquires leases on named locks, shared by every instance using the same database
+type Locker struct {
+	provideDB DBProviderFunc
+	owner     string
+	ttl       time.Duration
+}
+
+// NewLocker creates a locker whose leases expire after ttl unless renewed, 30 seconds when ttl is 0
+// Each locker is a distinct owner, so two lockers of the same process exclude each other
+func NewLocker(provideDB DBProviderFunc, ttl time.Duration) Locker {
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	return Locker{provideDB: provideDB, owner: string(uuid.GenV4()), ttl: ttl}
+}
+
+// Owner returns the id identifying the locker in the locks collection
+func (l Locker) Owner() string {
+	return l.owner
+}
+
+// EnsureIndexes creates the TTL index removing expired locks
+func (l Locker) EnsureIndexes(ctx context.Context) error {
+	col := l.provideDB().Collection(locksCollection)
+	return ensureCollectionIndexes(ctx, col, []IndexSpec{TTLIndexOn("expiresAt", 0)}, &IndexReport{})
+}
+
+// TryAcquire takes the lock if it is free or its lease expired, returning ErrLockHeld otherwise
+func (l Locker) TryAcquire(ctx context.Context, name string) (*Lease, error) {
+	token, err := l.nextToken(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": name,
+		"$or": bson.A{
+			bson.M{"owner": l.owner},
+			bson.M{"expiresAt": bson.M{"$lt": now}},
+		},
+		// a token taken before the current holder's can not take the lock over
+		"token": bson.M{"$lt": token},
+	}
+	update := bson.M{"$set": bson.M{"owner": l.owner, "token": token, "acquiredAt": now, "expiresAt": now.Add(l.ttl)}}
+	// when the lock is held the filter does not match, so the upsert collides with the existing lock
+	_, err = l.provideDB().Collection(locksCollection).UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrLockHeld
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to acquire lock %s", name)
+	}
+	return &Lease{Name: name, Token: token, ExpiresAt: now.Add(l.ttl), locker: l}, nil
+}
+
+// Acquire waits for the lock, trying to take it every retry until it succeeds or ctx is done
+func (l Locker) Acquire(ctx context.Context, name string, retry time.Duration) (*Lease, error) {
+	for {
+		lease, err := l.TryAcquire(ctx, name)
+		if err != ErrLockHeld {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retry):
+		}
+	}
+}
+
+// nextToken increments the fencing counter of the lock. Counters are kept apart from the locks,
+// so tokens keep increasing after the TTL index removed an expired lock
+func (l Locker) nextToken(ctx context.Context, name string) (int64, error) {
+	var fence struct {
+		Token int64 `bson:"token"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := l.provideDB().Collection(lockFencesCollection).
+		FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.M{"$inc": bson.M{"token": int64(1)}}, opts).
+		Decode(&fence)
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to issue fencing token for lock %s", name)
+	}
+	return fence.Token, nil
+}
+
+// Lease is a held lock
+type Lease struct {
+	Name string
+	// Token increases every time the lock is acquired. Writes guarded by the lock should carry it, so a store can
+	// reject the writes of a holder whose lease expired in favour of a later holder
+	Token     int64
+	ExpiresAt time.Time
+	locker    Locker
+}
+
+// Renew extends the lease by the locker's ttl, returning ErrLockLost when the lock is no longer held
+func (l *Lease) Renew(ctx context.Context) error {
+	expiresAt := time.Now().UTC().Add(l.locker.ttl)
+	res, err := l.locker.provideDB().Collection(locksCollection).UpdateOne(ctx, l.filter(), bson.M{"$set": bson.M{"expiresAt": expiresAt}})
+	if err != nil {
+		return errors.Wrapf(err, "unable to renew lock %s", l.Name)
+	}
+	if res.MatchedCount == 0 {
+		return ErrLockLost
+	}
+	l.ExpiresAt = expiresAt
+	return nil
+}
+
+// Release frees the lock, unless it was already taken by another owner
+func (l *Lease) Release(ctx context.Context) error {
+	_, err := l.locker.provideDB().Collection(locksCollection).DeleteOne(ctx, l.filter())
+	if err != nil {
+		return errors.Wrapf(err, "unable to release lock %s", l.Name)
+	}
+	return nil
+}
+
+// KeepAlive renews the lease every interval until ctx is done or a renewal fails
+// The returned channel receives the error that stopped the renewals, nil when ctx is done, and is then closed
+func (l *Lease) KeepAlive(ctx context.Context, interval time.Duration) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				done <- nil
+				return
+			case <-ticker.C:
+				if err := l.Renew(ctx); err != nil {
+					if ctx.Err() != nil {
+						done <- nil
+						return
+					}
+					done <- err
+					return
+				}
+			}
+		}
+	}()
+	return done
+}
+
+func (l *Lease) filter() bson.M {
+	return bson.M{"_id": l.Name, "owner": l.locker.owner, "token": l.Token}
+}
+
+// LeaderCallbacks are called as an instance gains and loses leadership
+type LeaderCallbacks struct {
+	// OnStartedLeading runs while the instance leads, ctx is cancelled once leadership is lost
+	OnStartedLeading func(ctx context.Context, token int64)
+	// OnStoppedLeading is called once leadership is lost, after ctx of OnStartedLeading is cancelled
+	OnStoppedLeading func()
+}
+
+// RunForLeader campaigns for the named lock until ctx is done, calling the callbacks as leadership is gained and lost
+// The lease is renewed every third of the ttl. Leadership is given up when OnStartedLeading returns or a renewal fails,
+// and campaigned for again
+func (l Locker) RunForLeader(ctx context.Context, name string, cb LeaderCallbacks) error {
+	interval := l.ttl / 3
+	for {
+		lease, err := l.Acquire(ctx, name, interval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.Println("Unable to campaign for leadership with error: ", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+			continue
+		}
+
+		l.lead(ctx, lease, interval, cb)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// lead runs the leader callbacks while the lease is renewed
+func (l Locker) lead(ctx context.Context, lease *Lease, interval time.Duration, cb LeaderCallbacks) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		if cb.OnStartedLeading != nil {
+			cb.OnStartedLeading(leaderCtx, lease.Token)
+		}
+	}()
+
+	select {
+	case err := <-lease.KeepAlive(leaderCtx, interval):
+		if err != nil {
+			log.Printf("Lost leadership of %s with error: %v", lease.Name, err)
+		}
+	case <-finished:
+	}
+	cancel()
+	<-finished
+
+	if err := lease.Release(context.Background()); err != nil {
+		log.Println("Unable to release leadership with error: ", err)
+	}
+	if cb.OnStoppedLeading != nil {
+		cb.OnStoppedLeading()
+	}
+}
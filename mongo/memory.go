@@ -0,0 +1,519 @@
+package mongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryCollection is an in memory implementation of CollectionAPI for unit tests
+// Filters support equality, $and, $or, $eq, $ne, $in, $nin, $gt, $gte, $lt, $lte and $exists,
+// and updates support $set, $unset and $inc. Dotted paths address fields of embedded documents,
+// and null matches missing fields as in mongo
+type MemoryCollection struct {
+	mu   sync.RWMutex
+	docs []bson.D
+}
+
+var _ CollectionAPI = &MemoryCollection{}
+
+// NewMemoryCollection creates an empty in memory collection
+func NewMemoryCollection() *MemoryCollection {
+	return &MemoryCollection{}
+}
+
+// InsertOneDoc adds a new document to the collection
+func (m *MemoryCollection) InsertOneDoc(doc interface{}) (*mongo.InsertOneResult, error) {
+	d, err := toDoc(doc)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := lookupField(d, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		d = append(bson.D{{Key: "_id", Value: id}}, d...)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs = append(m.docs, d)
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+// InsertBatch adds a list of documents to the collection
+func (m *MemoryCollection) InsertBatch(doc []interface{}) (*mongo.InsertManyResult, error) {
+	res := &mongo.InsertManyResult{}
+	for _, d := range doc {
+		r, err := m.InsertOneDoc(d)
+		if err != nil {
+			return res, err
+		}
+		res.InsertedIDs = append(res.InsertedIDs, r.InsertedID)
+	}
+	return res, nil
+}
+
+// FindByID finds by ID, a document in the collection
+func (m *MemoryCollection) FindByID(ID string, r interface{}) error {
+	return m.findOne(bson.M{"id": ID}, false, r)
+}
+
+// FindByField finds by a specific field, the FIRST document in the collection
+func (m *MemoryCollection) FindByField(key string, value string, r interface{}) error {
+	return m.findOne(bson.M{key: value}, false, r)
+}
+
+// FindByFilter finds by a passing in a filter
+func (m *MemoryCollection) FindByFilter(filter bson.M, r interface{}) error {
+	return m.findOne(filter, false, r)
+}
+
+// FindLatestByField finds by a specific field, the latest document in the collection
+func (m *MemoryCollection) FindLatestByField(key string, value string, r interface{}) error {
+	return m.findOne(bson.M{key: value}, true, r)
+}
+
+// FindAll returns all the documents in the collection
+// the 'onEach' function is called for each match as the cursor iterates
+func (m *MemoryCollection) FindAll(onEach func(c *mongo.Cursor) error) error {
+	return m.find(bson.M{}, onEach)
+}
+
+// FindMulti returns multiple documents that match the filter options criteria
+// the 'onEach' function is called for each match as the cursor iterates
+func (m *MemoryCollection) FindMulti(key string, value interface{}, onEach func(c *mongo.Cursor) error) error {
+	return m.find(bson.M{key: value}, onEach)
+}
+
+// FindMultiWithFilter returns multiple documents that match the filter options criteria
+// the 'onEach' function is called for each match as the cursor iterates
+func (m *MemoryCollection) FindMultiWithFilter(filter interface{}, onEach func(c *mongo.Cursor) error) error {
+	return m.find(filter, onEach)
+}
+
+// Replace replaces an existing document in the collection
+func (m *MemoryCollection) Replace(ID string, replacement interface{}) (*mongo.UpdateResult, error) {
+	return m.replaceOne(bson.M{"id": ID}, replacement)
+}
+
+// ReplaceWithFilter replaces an existing document, given a filter, in the collection
+func (m *MemoryCollection) ReplaceWithFilter(key, value string, replacement interface{}) (*mongo.UpdateResult, error) {
+	return m.replaceOne(bson.M{key: value}, replacement)
+}
+
+// Update updates a specific field in an existing document in the collection
+func (m *MemoryCollection) Update(ID string, key string, u interface{}) (*mongo.UpdateResult, error) {
+	return m.updateOne(bson.M{"id": ID}, bson.M{"$set": bson.M{key: u}})
+}
+
+// UpdateObject updates existing document in the collection
+func (m *MemoryCollection) UpdateObject(id string, changes interface{}) error {
+	_, err := m.updateOne(bson.M{"id": id}, bson.M{"$set": changes})
+	return err
+}
+
+// UpdateOneWithFilterOptions updates with filter conditions specified
+func (m *MemoryCollection) UpdateOneWithFilterOptions(filter interface{}, changes interface{}) error {
+	_, err := m.updateOne(filter, bson.M{"$set": changes})
+	return err
+}
+
+// Delete deletes a document from the collection
+func (m *MemoryCollection) Delete(ID string) error {
+	f, err := toDoc(bson.M{"id": ID})
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.docs {
+		if matches(d, f) {
+			m.docs = append(m.docs[:i], m.docs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Len returns the number of documents in the collection
+func (m *MemoryCollection) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.docs)
+}
+
+func (m *MemoryCollection) findOne(filter interface{}, latest bool, r interface{}) error {
+	matched, err := m.matching(filter)
+	if err != nil {
+		return err
+	}
+	if len(matched) == 0 {
+		return mongo.ErrNoDocuments
+	}
+	doc := matched[0]
+	if latest {
+		doc = matched[len(matched)-1]
+	}
+	return decodeDoc(doc, r)
+}
+
+func (m *MemoryCollection) find(filter interface{}, onEach func(c *mongo.Cursor) error) error {
+	matched, err := m.matching(filter)
+	if err != nil {
+		return err
+	}
+	docs := make([]interface{}, 0, len(matched))
+	for _, d := range matched {
+		docs = append(docs, d)
+	}
+	cur, err := mongo.NewCursorFromDocuments(docs, nil, nil)
+	if err != nil {
+		return err
+	}
+	return eachDoc(context.Background(), cur, onEach)
+}
+
+// matching returns the documents matching the filter, in insertion order
+func (m *MemoryCollection) matching(filter interface{}) ([]bson.D, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	matched := []bson.D{}
+	for _, d := range m.docs {
+		if matches(d, f) {
+			matched = append(matched, d)
+		}
+	}
+	return matched, nil
+}
+
+func (m *MemoryCollection) replaceOne(filter interface{}, replacement interface{}) (*mongo.UpdateResult, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	r, err := toDoc(replacement)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.docs {
+		if !matches(d, f) {
+			continue
+		}
+		id, _ := lookupField(d, "_id")
+		m.docs[i] = append(bson.D{{Key: "_id", Value: id}}, removeField(r, "_id")...)
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	return &mongo.UpdateResult{}, nil
+}
+
+func (m *MemoryCollection) updateOne(filter interface{}, update interface{}) (*mongo.UpdateResult, error) {
+	f, err := toDoc(filter)
+	if err != nil {
+		return nil, err
+	}
+	u, err := toDoc(update)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.docs {
+		if !matches(d, f) {
+			continue
+		}
+		updated, err := applyUpdate(d, u)
+		if err != nil {
+			return nil, err
+		}
+		m.docs[i] = updated
+		return &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+	}
+	return &mongo.UpdateResult{}, nil
+}
+
+// toDoc converts a document, filter or update of any marshallable type into a bson.D
+func toDoc(v interface{}) (bson.D, error) {
+	if v == nil {
+		return bson.D{}, nil
+	}
+	return withFields(v)
+}
+
+func decodeDoc(d bson.D, r interface{}) error {
+	bb, err := bson.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(bb, r)
+}
+
+// matches checks if a document matches a filter
+func matches(d bson.D, filter bson.D) bool {
+	for _, e := range filter {
+		switch e.Key {
+		case "$and", "$or":
+			clauses, _ := e.Value.(bson.A)
+			matchedAny := false
+			for _, c := range clauses {
+				sub, _ := c.(bson.D)
+				ok := matches(d, sub)
+				if e.Key == "$and" && !ok {
+					return false
+				}
+				matchedAny = matchedAny || ok
+			}
+			if e.Key == "$or" && !matchedAny {
+				return false
+			}
+		default:
+			v, found := lookupField(d, e.Key)
+			if !matchesCondition(v, found, e.Value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchesCondition(v interface{}, found bool, cond interface{}) bool {
+	ops, ok := cond.(bson.D)
+	if !ok || len(ops) == 0 || !strings.HasPrefix(ops[0].Key, "$") {
+		return equalsAt(v, found, cond)
+	}
+
+	for _, op := range ops {
+		var ok bool
+		switch op.Key {
+		case "$eq":
+			ok = equalsAt(v, found, op.Value)
+		case "$ne":
+			ok = !equalsAt(v, found, op.Value)
+		case "$in":
+			ok = inValues(v, found, op.Value)
+		case "$nin":
+			ok = !inValues(v, found, op.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			cmp, comparable := compareValues(v, op.Value)
+			ok = found && comparable && ((op.Key == "$gt" && cmp > 0) || (op.Key == "$gte" && cmp >= 0) ||
+				(op.Key == "$lt" && cmp < 0) || (op.Key == "$lte" && cmp <= 0))
+		case "$exists":
+			want, _ := op.Value.(bool)
+			ok = found == want
+		default:
+			return false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// equalsAt tells whether the value found at a path equals want, a missing value equals null as in mongo
+func equalsAt(v interface{}, found bool, want interface{}) bool {
+	if want == nil {
+		return !found || v == nil
+	}
+	return found && equalValues(v, want)
+}
+
+func inValues(v interface{}, found bool, values interface{}) bool {
+	list, _ := values.(bson.A)
+	for _, candidate := range list {
+		if equalsAt(v, found, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares values the way mongo does, numbers of any type compare by value
+// and an array equals a value when any of its elements does
+func equalValues(a, b interface{}) bool {
+	if arr, ok := a.(bson.A); ok {
+		if _, bIsArr := b.(bson.A); !bIsArr {
+			for _, e := range arr {
+				if equalValues(e, b) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	if cmp, ok := compareValues(a, b); ok {
+		return cmp == 0
+	}
+	return rawEqual(a, b)
+}
+
+// compareValues orders numbers, strings, times and object ids. ok is false for values that can not be ordered
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case primitive.DateTime:
+		if y, ok := b.(primitive.DateTime); ok {
+			return compareInts(int64(x), int64(y)), true
+		}
+	case primitive.ObjectID:
+		if y, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(x[:], y[:]), true
+		}
+	}
+	return 0, false
+}
+
+func compareInts(x, y int64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func rawEqual(a, b interface{}) bool {
+	ta, ba, errA := bson.MarshalValue(a)
+	tb, bb, errB := bson.MarshalValue(b)
+	return errA == nil && errB == nil && ta == tb && bytes.Equal(ba, bb)
+}
+
+// lookupField returns the value at a dotted path of a document
+func lookupField(d bson.D, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	var cur interface{} = d
+	for _, k := range keys {
+		doc, ok := cur.(bson.D)
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for _, e := range doc {
+			if e.Key == k {
+				cur, found = e.Value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// applyUpdate applies the $set, $unset and $inc operators of an update to a copy of the document
+func applyUpdate(d bson.D, update bson.D) (bson.D, error) {
+	out, err := toDoc(d)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range update {
+		fields, ok := op.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("update operator %s expects a document", op.Key)
+		}
+		for _, f := range fields {
+			switch op.Key {
+			case "$set":
+				out = setPath(out, strings.Split(f.Key, "."), f.Value)
+			case "$unset":
+				out = unsetPath(out, strings.Split(f.Key, "."))
+			case "$inc":
+				current, _ := lookupField(out, f.Key)
+				out = setPath(out, strings.Split(f.Key, "."), addNumbers(current, f.Value))
+			default:
+				return nil, errors.Errorf("update operator %s is not supported in memory", op.Key)
+			}
+		}
+	}
+	return out, nil
+}
+
+func setPath(d bson.D, keys []string, v interface{}) bson.D {
+	if len(keys) == 1 {
+		return setField(d, bson.E{Key: keys[0], Value: v})
+	}
+	child, _ := lookupField(d, keys[0])
+	sub, ok := child.(bson.D)
+	if !ok {
+		sub = bson.D{}
+	}
+	return setField(d, bson.E{Key: keys[0], Value: setPath(sub, keys[1:], v)})
+}
+
+func unsetPath(d bson.D, keys []string) bson.D {
+	if len(keys) == 1 {
+		return removeField(d, keys[0])
+	}
+	child, _ := lookupField(d, keys[0])
+	sub, ok := child.(bson.D)
+	if !ok {
+		return d
+	}
+	return setField(d, bson.E{Key: keys[0], Value: unsetPath(sub, keys[1:])})
+}
+
+func addNumbers(a, b interface{}) interface{} {
+	x, aIsInt := toInt64(a)
+	y, bIsInt := toInt64(b)
+	if (aIsInt || a == nil) && bIsInt {
+		return x + y
+	}
+	fx, _ := toFloat(a)
+	fy, _ := toFloat(b)
+	return fx + fy
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMemoryCollectionFilters(t *testing.T) {
+	m := NewMemoryCollection()
+	m.InsertBatch([]interface{}{
+		bson.D{{Key: "id", Value: "a"}, {Key: "n", Value: int32(1)}, {Key: "tags", Value: bson.A{"x", "y"}}, {Key: "addr", Value: bson.D{{Key: "city", Value: "Lagos"}}}},
+		bson.D{{Key: "id", Value: "b"}, {Key: "n", Value: int64(2)}, {Key: "tags", Value: bson.A{"y"}}, {Key: "note", Value: nil}},
+		bson.D{{Key: "id", Value: "c"}, {Key: "n", Value: 3.0}, {Key: "addr", Value: bson.D{{Key: "city", Value: "Abuja"}}}},
+	})
+
+	tests := []struct {
+		name   string
+		filter interface{}
+		want   []string
+	}{
+		{"equality", bson.M{"id": "b"}, []string{"b"}},
+		{"numbers of any type", bson.M{"n": 2}, []string{"b"}},
+		{"dotted path", bson.M{"addr.city": "Lagos"}, []string{"a"}},
+		{"array element", bson.M{"tags": "y"}, []string{"a", "b"}},
+		{"whole array", bson.M{"tags": bson.A{"y"}}, []string{"b"}},
+		{"$and", bson.M{"$and": bson.A{bson.M{"tags": "y"}, bson.M{"n": bson.M{"$gt": 1}}}}, []string{"b"}},
+		{"$or", bson.M{"$or": bson.A{bson.M{"id": "a"}, bson.M{"id": "c"}}}, []string{"a", "c"}},
+		{"$eq", bson.M{"id": bson.M{"$eq": "c"}}, []string{"c"}},
+		{"$ne", bson.M{"id": bson.M{"$ne": "c"}}, []string{"a", "b"}},
+		{"$ne on a missing field", bson.M{"addr.city": bson.M{"$ne": "Lagos"}}, []string{"b", "c"}},
+		{"$in", bson.M{"id": bson.M{"$in": bson.A{"a", "c"}}}, []string{"a", "c"}},
+		{"$nin", bson.M{"tags": bson.M{"$nin": bson.A{"x"}}}, []string{"b", "c"}},
+		{"$gt", bson.M{"n": bson.M{"$gt": 1}}, []string{"b", "c"}},
+		{"$gte", bson.M{"n": bson.M{"$gte": 2}}, []string{"b", "c"}},
+		{"$lt", bson.M{"n": bson.M{"$lt": 2}}, []string{"a"}},
+		{"$lte", bson.M{"n": bson.M{"$lte": 2}}, []string{"a", "b"}},
+		{"range", bson.M{"n": bson.D{{Key: "$gt", Value: 1}, {Key: "$lt", Value: 3}}}, []string{"b"}},
+		{"$exists", bson.M{"addr": bson.M{"$exists": true}}, []string{"a", "c"}},
+		{"not $exists", bson.M{"addr": bson.M{"$exists": false}}, []string{"b"}},
+		{"null matches null and missing", bson.M{"note": nil}, []string{"a", "b", "c"}},
+		{"$eq null", bson.M{"addr": bson.M{"$eq": nil}}, []string{"b"}},
+		{"$ne null", bson.M{"addr": bson.M{"$ne": nil}}, []string{"a", "c"}},
+		{"$in null", bson.M{"addr.city": bson.M{"$in": bson.A{nil, "Abuja"}}}, []string{"b", "c"}},
+		{"$nin null", bson.M{"addr": bson.M{"$nin": bson.A{nil}}}, []string{"a", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			err := m.FindMultiWithFilter(tt.filter, func(c *mongo.Cursor) error {
+				got = append(got, c.Current.Lookup("id").StringValue())
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Unable to find: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	migrationsCollection = "migrations"
+	// migrationLockName is the lock taken in the locks collection, see Locker
+	migrationLockName       = "migrations"
+	defaultMigrationLockTTL = 10 * time.Minute
+)
+
+// ErrMigrationLocked is returned when another instance is running migrations
+var ErrMigrationLocked = errors.New("migrations are locked by another instance")
+
+// MigrationFunc changes the documents or indexes of a database
+type MigrationFunc func(ctx context.Context, db *mongo.Database) error
+
+// Migration is a versioned change to a database
+type Migration struct {
+	Version     int64
+	Description string
+	Up          MigrationFunc
+	// Down reverts Up. Migrations without Down can not be rolled back
+	Down MigrationFunc
+}
+
+// migrationRecord is the document stored for each applied migration
+type migrationRecord struct {
+	Version     int64     `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"appliedAt"`
+}
+
+// Migrator applies and reverts migrations, recording the applied versions in the migrations collection
+// Instances take turns through the "migrations" lock of the locks collection, see Locker
+type Migrator struct {
+	provideDB  DBProviderFunc
+	migrations []Migration
+	locker     Locker
+	dryRun     bool
+}
+
+// NewMigrator creates a migrator for the migrations. Versions must be unique
+func NewMigrator(provideDB DBProviderFunc, migrations ...Migration) (*Migrator, error) {
+	sorted := append([]Migration{}, migrations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	for i, m := range sorted {
+		if m.Up == nil {
+			return nil, fmt.Errorf("migration %d has no Up function", m.Version)
+		}
+		if i > 0 && sorted[i-1].Version == m.Version {
+			return nil, fmt.Errorf("migration version %d is registered more than once", m.Version)
+		}
+	}
+	return &Migrator{
+		provideDB:  provideDB,
+		migrations: sorted,
+		locker:     NewLocker(provideDB, defaultMigrationLockTTL),
+	}, nil
+}
+
+// WithDryRun returns a copy of the migrator that lists the steps it would run without running them
+func (m Migrator) WithDryRun() *Migrator {
+	m.dryRun = true
+	return &m
+}
+
+// WithLockTTL returns a copy of the migrator whose lock expires after ttl, should the instance holding it die
+// The lock is renewed every third of ttl while migrations run
+func (m Migrator) WithLockTTL(ttl time.Duration) *Migrator {
+	m.locker = NewLocker(m.provideDB, ttl)
+	return &m
+}
+
+// Pending returns the migrations that have not been applied, in the order they would run
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.appliedVersions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, mig := range m.migrations {
+		if !applied[mig.Version] {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies all pending migrations and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, -1)
+}
+
+// UpTo applies the pending migrations up to and including version and returns them. A negative version applies all
+func (m *Migrator) UpTo(ctx context.Context, version int64) ([]Migration, error) {
+	steps := []Migration{}
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, mig := range pending {
+			if version >= 0 && mig.Version > version {
+				break
+			}
+			if !m.dryRun {
+				if err := m.apply(ctx, mig); err != nil {
+					return err
+				}
+			}
+			steps = append(steps, mig)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+// Down reverts the last n applied migrations, latest first, and returns them
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	steps := []Migration{}
+	err := m.withLock(ctx, func(ctx context.Context) error {
+		applied, err := m.appliedVersions(ctx)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(steps) < n; i-- {
+			mig := m.migrations[i]
+			if !applied[mig.Version] {
+				continue
+			}
+			if mig.Down == nil {
+				return fmt.Errorf("migration %d can not be reverted, it has no Down function", mig.Version)
+			}
+			if !m.dryRun {
+				if err := m.revert(ctx, mig); err != nil {
+					return err
+				}
+			}
+			steps = append(steps, mig)
+		}
+		return nil
+	})
+	return steps, err
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration) error {
+	db := m.provideDB()
+	if err := mig.Up(ctx, db); err != nil {
+		return errors.Wrapf(err, "unable to apply migration %d", mig.Version)
+	}
+	record := migrationRecord{Version: mig.Version, Description: mig.Description, AppliedAt: time.Now().UTC()}
+	if _, err := db.Collection(migrationsCollection).InsertOne(ctx, record); err != nil {
+		return errors.Wrapf(err, "migration %d was applied but could not be recorded", mig.Version)
+	}
+	return nil
+}
+
+func (m *Migrator) revert(ctx context.Context, mig Migration) error {
+	db := m.provideDB()
+	if err := mig.Down(ctx, db); err != nil {
+		return errors.Wrapf(err, "unable to revert migration %d", mig.Version)
+	}
+	if _, err := db.Collection(migrationsCollection).DeleteOne(ctx, bson.M{"_id": mig.Version}); err != nil {
+		return errors.Wrapf(err, "migration %d was reverted but could not be recorded", mig.Version)
+	}
+	return nil
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context) (map[int64]bool, error) {
+	cur, err := m.provideDB().Collection(migrationsCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list applied migrations")
+	}
+	defer cur.Close(ctx)
+
+	applied := map[int64]bool{}
+	for cur.Next(ctx) {
+		var r migrationRecord
+		if err := cur.Decode(&r); err != nil {
+			return nil, err
+		}
+		applied[r.Version] = true
+	}
+	return applied, cur.Err()
+}
+
+// withLock runs fn while holding the migration lock, renewing it every third of the lock ttl
+// ctx of fn is cancelled when a renewal fails, as another instance may then take the lock over
+// A dry run does not take the lock
+func (m *Migrator) withLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	if m.dryRun {
+		return fn(ctx)
+	}
+
+	lease, err := m.locker.TryAcquire(ctx, migrationLockName)
+	if err == ErrLockHeld {
+		return ErrMigrationLocked
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to acquire migration lock")
+	}
+	defer func() {
+		if err := lease.Release(context.Background()); err != nil {
+			log.Println("Unable to release migration lock with error: ", err)
+		}
+	}()
+
+	lockCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	renewals := lease.KeepAlive(lockCtx, m.locker.ttl/3)
+	var renewErr error
+	watched := make(chan struct{})
+	go func() {
+		defer close(watched)
+		if err := <-renewals; err != nil {
+			renewErr = err
+			cancel()
+		}
+	}()
+
+	err = fn(lockCtx)
+	cancel()
+	<-watched
+	if renewErr != nil {
+		return errors.Wrap(renewErr, "migrations stopped as the migration lock could not be renewed")
+	}
+	return err
+}
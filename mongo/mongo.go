@@ -8,9 +8,35 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// CollectionAPI is the set of Collection methods services depend on
+// Depending on it rather than on Collection lets unit tests swap in NewMemoryCollection
+type CollectionAPI interface {
+	InsertOneDoc(doc interface{}) (*mongo.InsertOneResult, error)
+	InsertBatch(doc []interface{}) (*mongo.InsertManyResult, error)
+	FindByID(ID string, r interface{}) error
+	FindByField(key string, value string, r interface{}) error
+	FindByFilter(filter bson.M, r interface{}) error
+	FindLatestByField(key string, value string, r interface{}) error
+	FindAll(onEach func(c *mongo.Cursor) error) error
+	FindMulti(key string, value interface{}, onEach func(c *mongo.Cursor) error) error
+	FindMultiWithFilter(filter interface{}, onEach func(c *mongo.Cursor) error) error
+	Replace(ID string, replacement interface{}) (*mongo.UpdateResult, error)
+	ReplaceWithFilter(key, value string, replacement interface{}) (*mongo.UpdateResult, error)
+	Update(ID string, key string, u interface{}) (*mongo.UpdateResult, error)
+	UpdateObject(id string, changes interface{}) error
+	UpdateOneWithFilterOptions(filter interface{}, changes interface{}) error
+	Delete(ID string) error
+}
+
+var _ CollectionAPI = &Collection{}
+
 // Collection is a repreentation of a mongo collection, like an SQL table
 type Collection struct {
-	col *mongo.Collection
+	col          *mongo.Collection
+	ctx          context.Context
+	audit        auditConfig
+	versionField string
+	enc          *fieldEncryption
 }
 
 // NewCollection creates a new collection
@@ -20,99 +46,133 @@ func NewCollection(provideDB DBProviderFunc, cn string) Collection {
 	}
 }
 
+// WithContext returns a copy of the collection whose operations run with ctx,
+// so cancellation and deadlines of the caller reach mongo
+func (c Collection) WithContext(ctx context.Context) Collection {
+	c.ctx = ctx
+	return c
+}
+
+// getContext returns the context operations on the collection should run with
+func (c Collection) getContext() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 // InsertOneDoc adds a new document to the database
 func (c Collection) InsertOneDoc(doc interface{}) (*mongo.InsertOneResult, error) {
-	return c.col.InsertOne(context.Background(), doc)
+	doc, err := c.stampInsert(doc)
+	if err != nil {
+		return nil, err
+	}
+	return c.col.InsertOne(c.getContext(), doc)
 }
 
 // InsertBatch adds a list of documents to the database
 func (c *Collection) InsertBatch(doc []interface{}) (*mongo.InsertManyResult, error) {
-	return c.col.InsertMany(context.Background(), doc)
+	docs := make([]interface{}, 0, len(doc))
+	for _, d := range doc {
+		stamped, err := c.stampInsert(d)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, stamped)
+	}
+	return c.col.InsertMany(c.getContext(), docs)
 }
 
 // FindByID finds by ID, a document in the mongo database
 func (c Collection) FindByID(ID string, r interface{}) error {
 	filter := bson.M{"id": ID}
-	err := c.col.FindOne(context.Background(), filter).Decode(r)
-	return err
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return err
+	}
+	return c.decodeOne(c.col.FindOne(c.getContext(), scoped), r)
 }
 
 // FindByField finds by a specific field, the FIRST document in the mongo database. See FindLatestByField to get the most recent document
 func (c Collection) FindByField(key string, value string, r interface{}) error {
 	filter := bson.M{key: value}
-	err := c.col.FindOne(context.Background(), filter).Decode(r)
-	return err
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return err
+	}
+	return c.decodeOne(c.col.FindOne(c.getContext(), scoped), r)
 }
 
 // FindByFilter finds by a passing in a filter
 func (c Collection) FindByFilter(filter bson.M, r interface{}) error {
-	err := c.col.FindOne(context.Background(), filter).Decode(r)
-	return err
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return err
+	}
+	return c.decodeOne(c.col.FindOne(c.getContext(), scoped), r)
 }
 
 // FindLatestByField finds by a specific field, the latest document in Mongo
 func (c Collection) FindLatestByField(key string, value string, r interface{}) error {
 	filter := bson.M{key: value}
 	newOpt := options.FindOneOptions{Sort: bson.M{"_id": -1}}
-	err := c.col.FindOne(context.Background(), filter, &newOpt).Decode(r)
-	return err
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return err
+	}
+	return c.decodeOne(c.col.FindOne(c.getContext(), scoped, &newOpt), r)
 }
 
 // FindAll returns all the documents in the collection
 // the 'onEach' function is called for each match as the cursor iterates
 func (c *Collection) FindAll(onEach func(c *mongo.Cursor) error) error {
-	ctx := context.Background()
-
-	filter := bson.M{}
-	cur, err := c.col.Find(ctx, filter)
-	if err != nil {
-		return err
-	}
-	defer cur.Close(ctx)
-
-	for cur.Next(ctx) {
-		if err := onEach(cur); err != nil {
-			return err
-		}
-	}
-	if err := cur.Err(); err != nil {
-		return err
-	}
-	return nil
+	return c.find(bson.M{}, onEach)
 }
 
 //FindMulti returns multiple documents that match the filter options criteria
 // the 'onEach' function is called for each match as the cursor iterates
 func (c *Collection) FindMulti(key string, value interface{}, onEach func(c *mongo.Cursor) error) error {
-	ctx := context.Background()
+	return c.find(bson.M{key: value}, onEach)
+}
 
-	filter := bson.M{key: value}
-	cur, err := c.col.Find(ctx, filter)
-	if err != nil {
-		return err
-	}
-	defer cur.Close(ctx)
+// FindMultiWithFilter returns multiple documents that match the filter options criteria
+// the 'onEach' function is called for each match as the cursor iterates
+func (c *Collection) FindMultiWithFilter(filter interface{}, onEach func(c *mongo.Cursor) error) error {
+	return c.find(filter, onEach)
+}
 
-	for cur.Next(ctx) {
-		if err := onEach(cur); err != nil {
-			return err
-		}
-	}
-	if err := cur.Err(); err != nil {
+// FindWithQuery returns the documents that match a query, honouring its sort, projection, skip and limit
+// the 'onEach' function is called for each match as the cursor iterates
+func (c Collection) FindWithQuery(q *Query, onEach func(c *mongo.Cursor) error) error {
+	return c.find(q, onEach, q.FindOptions())
+}
+
+// FindOneWithQuery finds the first document that matches a query
+func (c Collection) FindOneWithQuery(q *Query, r interface{}) error {
+	scoped, err := c.scope(q)
+	if err != nil {
 		return err
 	}
-	return nil
+	return c.decodeOne(c.col.FindOne(c.getContext(), scoped, q.FindOneOptions()), r)
 }
 
-// FindMultiWithFilter returns multiple documents that match the filter options criteria
-// the 'onEach' function is called for each match as the cursor iterates
-func (c *Collection) FindMultiWithFilter(filter interface{}, onEach func(c *mongo.Cursor) error) error {
-	ctx := context.Background()
+// find calls 'onEach' for every document matching the filter as the cursor iterates
+func (c Collection) find(filter interface{}, onEach func(c *mongo.Cursor) error, opts ...*options.FindOptions) error {
+	ctx := c.getContext()
 
-	cur, err := c.col.Find(ctx, filter)
+	scoped, err := c.scope(filter)
 	if err != nil {
 		return err
 	}
+	cur, err := c.col.Find(ctx, scoped, opts...)
+	if err != nil {
+		return err
+	}
+	return eachDoc(ctx, cur, c.decrypting(onEach))
+}
+
+// eachDoc calls 'onEach' for every document of the cursor, closing it once done
+func eachDoc(ctx context.Context, cur *mongo.Cursor, onEach func(c *mongo.Cursor) error) error {
 	defer cur.Close(ctx)
 
 	for cur.Next(ctx) {
@@ -128,52 +188,82 @@ func (c *Collection) FindMultiWithFilter(filter interface{}, onEach func(c *mong
 
 // Replace replaces an existing document in the database
 func (c Collection) Replace(ID string, replacement interface{}) (*mongo.UpdateResult, error) {
-	filter := bson.M{"id": ID}
-	return c.col.ReplaceOne(context.Background(), filter, replacement)
+	filter, err := c.scope(bson.M{"id": ID})
+	if err != nil {
+		return nil, err
+	}
+	return c.replaceOne(filter, replacement, false)
 }
 
 // ReplaceWithFilter replaces an existing document, given a filter, in the database
 func (c Collection) ReplaceWithFilter(key, value string, replacement interface{}) (*mongo.UpdateResult, error) {
-	filter := bson.M{key: value}
-	return c.col.ReplaceOne(context.Background(), filter, replacement)
+	filter, err := c.scope(bson.M{key: value})
+	if err != nil {
+		return nil, err
+	}
+	return c.replaceOne(filter, replacement, false)
 }
 
 // Update updates a specific field in an existing document in the database
 func (c Collection) Update(ID string, key string, u interface{}) (*mongo.UpdateResult, error) {
-	filter := bson.M{"id": ID}
-	update := bson.M{"$set": bson.M{key: u}}
-	return c.col.UpdateOne(context.Background(), filter, update)
+	filter, err := c.scope(bson.M{"id": ID})
+	if err != nil {
+		return nil, err
+	}
+	changes, err := c.stampSet(bson.M{key: u})
+	if err != nil {
+		return nil, err
+	}
+	return c.col.UpdateOne(c.getContext(), filter, c.updateDoc(changes))
 }
 
 // UpdateObject updates existing document in the database
 func (c *Collection) UpdateObject(id string, changes interface{}) error {
-	filter := bson.D{{"id", id}}
-	update := bson.D{
-		{"$set", changes},
+	filter, err := c.scope(bson.D{{"id", id}})
+	if err != nil {
+		return err
 	}
-	_, err := c.col.UpdateOne(context.Background(), filter, update)
+	changes, err = c.stampSet(changes)
+	if err != nil {
+		return err
+	}
+	_, err = c.col.UpdateOne(c.getContext(), filter, c.updateDoc(changes))
 	return err
 }
 
 // UpdateOneWithFilterOptions updates with filter conditions specified
 func (c *Collection) UpdateOneWithFilterOptions(filter interface{}, changes interface{}) error {
-	update := bson.D{
-		{"$set", changes},
+	filter, err := c.scope(filter)
+	if err != nil {
+		return err
+	}
+	changes, err = c.stampSet(changes)
+	if err != nil {
+		return err
 	}
-	_, err := c.col.UpdateOne(context.Background(), filter, update)
+	_, err = c.col.UpdateOne(c.getContext(), filter, c.updateDoc(changes))
 	return err
 }
 
 // Delete deletes a document from a collection in the data
+// When soft deletes are on the document is marked with deletedAt instead, see WithSoftDelete
 func (c Collection) Delete(ID string) error {
 	filter := bson.M{"id": ID}
-	_, err := c.col.DeleteOne(context.Background(), filter)
+	if c.audit.softDelete {
+		scoped, err := c.scope(filter)
+		if err != nil {
+			return err
+		}
+		_, err = c.col.UpdateOne(c.getContext(), scoped, c.updateDoc(bson.M{deletedAtField: c.now()}))
+		return err
+	}
+	_, err := c.col.DeleteOne(c.getContext(), filter)
 	return err
 }
 
 // deleteAll deletes all documents in a collection
 func (c *Collection) deleteAll() error {
-	_, err := c.col.DeleteMany(context.Background(), bson.D{})
+	_, err := c.col.DeleteMany(c.getContext(), bson.D{})
 	return err
 }
 
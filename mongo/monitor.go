@@ -0,0 +1,253 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const redactedValue = "?"
+
+// queryFilterFields are the fields holding the filter of each monitored command
+var queryFilterFields = map[string][]string{
+	"find":          {"filter"},
+	"count":         {"query"},
+	"distinct":      {"query"},
+	"findAndModify": {"query"},
+	"update":        {"updates", "q"},
+	"delete":        {"deletes", "q"},
+	"aggregate":     {"pipeline"},
+	"insert":        nil,
+	"getMore":       nil,
+}
+
+// QueryEvent describes a command sent to mongo
+type QueryEvent struct {
+	Database   string
+	Collection string
+	Operation  string
+	Duration   time.Duration
+	// Filter is the filter of the command with every value replaced by ?, so it can be logged without leaking data
+	Filter string
+	Slow   bool
+	Err    string
+}
+
+// QueryMetrics records the commands sent to mongo
+type QueryMetrics interface {
+	RecordQuery(e QueryEvent)
+}
+
+// QueryMonitorOptions configures the logging of the commands sent to mongo
+type QueryMonitorOptions struct {
+	// SlowThreshold flags the commands that take longer. Slow commands and failures are always logged
+	SlowThreshold time.Duration
+	// LogAll logs every command, not only the slow and failed ones
+	LogAll bool
+	// Metrics records every command when set
+	Metrics QueryMetrics
+}
+
+// queryMonitor tracks commands between their start and end to log and record them
+type queryMonitor struct {
+	opts    QueryMonitorOptions
+	mu      sync.Mutex
+	started map[int64]QueryEvent
+}
+
+// NewQueryMonitor creates a driver command monitor logging the collection, operation, duration and redacted filter of commands
+// It is set on the client by DBConfig.ToProvider, and can be set with options.Client().SetMonitor on clients created elsewhere
+func NewQueryMonitor(opts QueryMonitorOptions) *event.CommandMonitor {
+	m := &queryMonitor{opts: opts, started: map[int64]QueryEvent{}}
+	return &event.CommandMonitor{
+		Started: m.onStarted,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			m.onFinished(e.RequestID, e.Duration, "")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			m.onFinished(e.RequestID, e.Duration, e.Failure)
+		},
+	}
+}
+
+func (m *queryMonitor) onStarted(ctx context.Context, e *event.CommandStartedEvent) {
+	filterPath, ok := queryFilterFields[e.CommandName]
+	if !ok {
+		return
+	}
+
+	qe := QueryEvent{Database: e.DatabaseName, Operation: e.CommandName}
+	if e.CommandName == "getMore" {
+		qe.Collection, _ = e.Command.Lookup("collection").StringValueOK()
+	} else {
+		qe.Collection, _ = e.Command.Lookup(e.CommandName).StringValueOK()
+	}
+	if filterPath != nil {
+		qe.Filter = redactedFilter(e.Command, filterPath)
+	}
+
+	m.mu.Lock()
+	m.started[e.RequestID] = qe
+	m.mu.Unlock()
+}
+
+func (m *queryMonitor) onFinished(requestID int64, d time.Duration, failure string) {
+	m.mu.Lock()
+	qe, ok := m.started[requestID]
+	delete(m.started, requestID)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	qe.Duration = d
+	qe.Err = failure
+	qe.Slow = m.opts.SlowThreshold > 0 && d >= m.opts.SlowThreshold
+	if m.opts.Metrics != nil {
+		m.opts.Metrics.RecordQuery(qe)
+	}
+	if m.opts.LogAll || qe.Slow || qe.Err != "" {
+		logQuery(qe)
+	}
+}
+
+func logQuery(e QueryEvent) {
+	msg := "Mongo query"
+	if e.Slow {
+		msg = "Slow mongo query"
+	}
+	if e.Err != "" {
+		log.Printf("%s db=%s collection=%s op=%s duration=%s filter=%s with error: %s", msg, e.Database, e.Collection, e.Operation, e.Duration, e.Filter, e.Err)
+		return
+	}
+	log.Printf("%s db=%s collection=%s op=%s duration=%s filter=%s", msg, e.Database, e.Collection, e.Operation, e.Duration, e.Filter)
+}
+
+// redactedFilter returns the filter found at path in the command as extended JSON, with every value replaced by ?
+// Array fields along the path, such as the updates of an update command, are followed through their first element
+func redactedFilter(cmd bson.Raw, path []string) string {
+	v := cmd.Lookup(path[0])
+	for _, key := range path[1:] {
+		if v.Type == bsontype.Array {
+			first, err := v.Array().IndexErr(0)
+			if err != nil {
+				return ""
+			}
+			v = first.Value()
+		}
+		doc, ok := v.DocumentOK()
+		if !ok {
+			return ""
+		}
+		v = doc.Lookup(key)
+	}
+	if v.Type != bsontype.EmbeddedDocument && v.Type != bsontype.Array {
+		return ""
+	}
+
+	var filter interface{}
+	if err := v.Unmarshal(&filter); err != nil {
+		return ""
+	}
+	bb, err := bson.MarshalExtJSON(bson.D{{Key: "f", Value: redact(filter)}}, false, false)
+	if err != nil {
+		return ""
+	}
+	// strip the {"f": ... } wrapper needed to marshal arrays
+	return string(bb[len(`{"f":`) : len(bb)-1])
+}
+
+// redact replaces the values of a decoded filter by ?, keeping field names and operators
+func redact(v interface{}) interface{} {
+	switch t := v.(type) {
+	case bson.D:
+		out := bson.D{}
+		for _, e := range t {
+			out = append(out, bson.E{Key: e.Key, Value: redact(e.Value)})
+		}
+		return out
+	case bson.A:
+		out := bson.A{}
+		for _, e := range t {
+			out = append(out, redact(e))
+		}
+		return out
+	default:
+		return redactedValue
+	}
+}
+
+// QueryStat aggregates the commands of one operation on one collection
+type QueryStat struct {
+	Count         int64         `json:"count"`
+	Slow          int64         `json:"slow"`
+	Failed        int64         `json:"failed"`
+	TotalDuration time.Duration `json:"totalDuration"`
+	MaxDuration   time.Duration `json:"maxDuration"`
+}
+
+// QueryStats is an in memory QueryMetrics, keyed by collection.operation
+type QueryStats struct {
+	mu    sync.Mutex
+	stats map[string]QueryStat
+}
+
+// NewQueryStats creates empty query statistics
+func NewQueryStats() *QueryStats {
+	return &QueryStats{stats: map[string]QueryStat{}}
+}
+
+// RecordQuery adds a command to the statistics
+func (s *QueryStats) RecordQuery(e QueryEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := e.Collection + "." + e.Operation
+	st := s.stats[key]
+	st.Count++
+	if e.Slow {
+		st.Slow++
+	}
+	if e.Err != "" {
+		st.Failed++
+	}
+	st.TotalDuration += e.Duration
+	if e.Duration > st.MaxDuration {
+		st.MaxDuration = e.Duration
+	}
+	s.stats[key] = st
+}
+
+// Snapshot returns a copy of the statistics
+func (s *QueryStats) Snapshot() map[string]QueryStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]QueryStat, len(s.stats))
+	for k, v := range s.stats {
+		out[k] = v
+	}
+	return out
+}
+
+// SlowestOperations returns the keys of the n operations with the longest maximum duration, slowest first
+func (s *QueryStats) SlowestOperations(n int) []string {
+	snapshot := s.Snapshot()
+	keys := make([]string, 0, len(snapshot))
+	for k := range snapshot {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return snapshot[keys[i]].MaxDuration > snapshot[keys[j]].MaxDuration
+	})
+	if n < len(keys) {
+		keys = keys[:n]
+	}
+	return keys
+}
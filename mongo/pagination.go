@@ -0,0 +1,269 @@
+package mongo
+
+import (
+	"encoding/base64"
+	"strings"
+
+	"github.com/babyfaceEasy/commons/commonerror"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPageLimit = int64(20)
+	cursorParamKey   = "cursor"
+)
+
+// PageOptions configures an offset (skip/limit) paginated query
+type PageOptions struct {
+	Skip       int64
+	Limit      int64
+	Sort       interface{}
+	Projection interface{}
+}
+
+// PageInfo describes the page returned by an offset paginated query
+type PageInfo struct {
+	Skip    int64 `json:"skip"`
+	Limit   int64 `json:"limit"`
+	Total   int64 `json:"total"`
+	HasMore bool  `json:"hasMore"`
+}
+
+// CursorOptions configures a keyset (cursor) paginated query
+// Results are ordered by SortField, then by _id to break ties. SortField defaults to _id.
+// Documents without SortField come first in ascending order and last in descending order.
+// Projection always keeps SortField and _id, which make up the cursor
+type CursorOptions struct {
+	Cursor     string
+	Limit      int64
+	SortField  string
+	Descending bool
+	Projection interface{}
+}
+
+// CursorInfo describes the page returned by a keyset paginated query
+// NextCursor is empty when there are no more documents
+type CursorInfo struct {
+	Limit      int64  `json:"limit"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// pageCursor is the decoded form of an opaque cursor
+type pageCursor struct {
+	Key bson.RawValue `bson:"k"`
+	ID  bson.RawValue `bson:"i"`
+}
+
+// FindPage returns a page of the documents matching the filter along with the total number of matches
+// the 'onEach' function is called for each match as the cursor iterates
+func (c Collection) FindPage(filter interface{}, opts PageOptions, onEach func(c *mongo.Cursor) error) (PageInfo, error) {
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageLimit
+	}
+
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return PageInfo{}, err
+	}
+	total, err := c.col.CountDocuments(c.getContext(), scoped)
+	if err != nil {
+		return PageInfo{}, err
+	}
+
+	findOpts := options.Find().SetSkip(opts.Skip).SetLimit(opts.Limit)
+	if opts.Sort != nil {
+		findOpts.SetSort(opts.Sort)
+	}
+	if opts.Projection != nil {
+		findOpts.SetProjection(opts.Projection)
+	}
+
+	if err := c.find(filter, onEach, findOpts); err != nil {
+		return PageInfo{}, err
+	}
+
+	return PageInfo{
+		Skip:    opts.Skip,
+		Limit:   opts.Limit,
+		Total:   total,
+		HasMore: opts.Skip+opts.Limit < total,
+	}, nil
+}
+
+// FindAfter returns the page of documents matching the filter that follows the position in opts.Cursor
+// the 'onEach' function is called for each match as the cursor iterates
+// The returned CursorInfo holds the cursor to pass in to get the next page
+func (c Collection) FindAfter(filter interface{}, opts CursorOptions, onEach func(c *mongo.Cursor) error) (CursorInfo, error) {
+	ctx := c.getContext()
+	onEach = c.decrypting(onEach)
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultPageLimit
+	}
+	if opts.SortField == "" {
+		opts.SortField = "_id"
+	}
+
+	direction, op := 1, "$gt"
+	if opts.Descending {
+		direction, op = -1, "$lt"
+	}
+
+	if opts.Cursor != "" {
+		pc, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return CursorInfo{}, err
+		}
+		filter = bson.M{"$and": bson.A{filter, afterFilter(opts, op, pc)}}
+	}
+
+	sort := bson.D{{Key: opts.SortField, Value: direction}}
+	if opts.SortField != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: direction})
+	}
+	// one extra document is fetched to know whether there is a next page
+	findOpts := options.Find().SetSort(sort).SetLimit(opts.Limit + 1)
+	if opts.Projection != nil {
+		projection, err := cursorProjection(opts.Projection, opts.SortField)
+		if err != nil {
+			return CursorInfo{}, err
+		}
+		findOpts.SetProjection(projection)
+	}
+
+	scoped, err := c.scope(filter)
+	if err != nil {
+		return CursorInfo{}, err
+	}
+	cur, err := c.col.Find(ctx, scoped, findOpts)
+	if err != nil {
+		return CursorInfo{}, err
+	}
+	defer cur.Close(ctx)
+
+	var (
+		seen int64
+		last pageCursor
+	)
+	for cur.Next(ctx) {
+		if seen == opts.Limit {
+			next, err := encodeCursor(last)
+			if err != nil {
+				return CursorInfo{}, err
+			}
+			return CursorInfo{Limit: opts.Limit, NextCursor: next}, nil
+		}
+		if err := onEach(cur); err != nil {
+			return CursorInfo{}, err
+		}
+		last = pageCursor{
+			Key: cur.Current.Lookup(strings.Split(opts.SortField, ".")...),
+			ID:  cur.Current.Lookup("_id"),
+		}
+		// documents without the sort field are ordered as null
+		if last.Key.Type == 0 {
+			last.Key = bson.RawValue{Type: bsontype.Null}
+		}
+		seen++
+	}
+	if err := cur.Err(); err != nil {
+		return CursorInfo{}, err
+	}
+	return CursorInfo{Limit: opts.Limit}, nil
+}
+
+// afterFilter matches the documents ordered after the cursor. Null and missing sort values come before all others,
+// and do not compare with $gt and $lt, so they are matched separately
+func afterFilter(opts CursorOptions, op string, pc pageCursor) bson.M {
+	if opts.SortField == "_id" {
+		return bson.M{"_id": bson.M{op: pc.ID}}
+	}
+	sameKey := bson.M{opts.SortField: bson.M{"$eq": pc.Key}, "_id": bson.M{op: pc.ID}}
+	switch {
+	case pc.Key.Type == bsontype.Null && opts.Descending:
+		return bson.M{opts.SortField: nil, "_id": bson.M{op: pc.ID}}
+	case pc.Key.Type == bsontype.Null:
+		return bson.M{"$or": bson.A{bson.M{opts.SortField: bson.M{"$ne": nil}}, sameKey}}
+	case opts.Descending:
+		return bson.M{"$or": bson.A{bson.M{opts.SortField: bson.M{op: pc.Key}}, sameKey, bson.M{opts.SortField: nil}}}
+	}
+	return bson.M{"$or": bson.A{bson.M{opts.SortField: bson.M{op: pc.Key}}, sameKey}}
+}
+
+// cursorProjection returns the projection with the sort field and _id kept, as the cursor is made of them
+func cursorProjection(projection interface{}, sortField string) (bson.D, error) {
+	p, err := withFields(projection)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read projection")
+	}
+	inclusion := false
+	for _, e := range p {
+		if e.Key != "_id" && !isExclusion(e.Value) {
+			inclusion = true
+		}
+	}
+
+	out := bson.D{}
+	for _, e := range p {
+		if e.Key != "_id" && e.Key != sortField {
+			out = append(out, e)
+		}
+	}
+	if inclusion {
+		out = append(out, bson.E{Key: sortField, Value: 1})
+		if sortField != "_id" {
+			out = append(out, bson.E{Key: "_id", Value: 1})
+		}
+	}
+	return out, nil
+}
+
+// isExclusion tells whether a projection value excludes its field
+func isExclusion(v interface{}) bool {
+	switch n := v.(type) {
+	case bool:
+		return !n
+	case int32:
+		return n == 0
+	case int64:
+		return n == 0
+	case float64:
+		return n == 0
+	}
+	return false
+}
+
+func encodeCursor(pc pageCursor) (string, error) {
+	bb, err := bson.Marshal(pc)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode page cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(bb), nil
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	invalidErr := commonerror.NewErrorParams(cursorParamKey, "Invalid cursor").ToBadRequest()
+
+	bb, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return pageCursor{}, invalidErr
+	}
+	var pc pageCursor
+	if err := bson.Unmarshal(bb, &pc); err != nil || pc.ID.Type == 0 {
+		return pageCursor{}, invalidErr
+	}
+	// cursors come from clients, a document key would be read as operators by the filter
+	if pc.Key.Type == bsontype.EmbeddedDocument || pc.Key.Type == bsontype.Array {
+		return pageCursor{}, invalidErr
+	}
+	return pc, nil
+}
@@ -0,0 +1,252 @@
+package mongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SortOrder is the direction documents are sorted in
+type SortOrder int
+
+const (
+	// Ascending sorts documents from the lowest to the highest value
+	Ascending SortOrder = 1
+	// Descending sorts documents from the highest to the lowest value
+	Descending SortOrder = -1
+)
+
+// operators holds the operator conditions ($gt, $in, ...) on a single field
+type operators bson.D
+
+// Query builds a filter along with sort, projection and limit options
+// A Query can be passed in as the filter of any Collection method, it is marshalled into its filter
+type Query struct {
+	filter     bson.D
+	logical    bson.D
+	sort       bson.D
+	projection bson.D
+	limit      int64
+	skip       int64
+}
+
+// NewQuery creates an empty query, matching all documents
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Eq matches documents where field equals v
+func (q *Query) Eq(field string, v interface{}) *Query {
+	for i, e := range q.filter {
+		if e.Key == field {
+			q.filter[i].Value = v
+			return q
+		}
+	}
+	q.filter = append(q.filter, bson.E{Key: field, Value: v})
+	return q
+}
+
+// Ne matches documents where field does not equal v
+func (q *Query) Ne(field string, v interface{}) *Query {
+	return q.op(field, "$ne", v)
+}
+
+// In matches documents where field equals any of the values
+func (q *Query) In(field string, values ...interface{}) *Query {
+	return q.op(field, "$in", bson.A(values))
+}
+
+// Nin matches documents where field equals none of the values
+func (q *Query) Nin(field string, values ...interface{}) *Query {
+	return q.op(field, "$nin", bson.A(values))
+}
+
+// Gt matches documents where field is greater than v
+func (q *Query) Gt(field string, v interface{}) *Query {
+	return q.op(field, "$gt", v)
+}
+
+// Gte matches documents where field is greater than or equal to v
+func (q *Query) Gte(field string, v interface{}) *Query {
+	return q.op(field, "$gte", v)
+}
+
+// Lt matches documents where field is less than v
+func (q *Query) Lt(field string, v interface{}) *Query {
+	return q.op(field, "$lt", v)
+}
+
+// Lte matches documents where field is less than or equal to v
+func (q *Query) Lte(field string, v interface{}) *Query {
+	return q.op(field, "$lte", v)
+}
+
+// Between matches documents where field is in the inclusive range [min, max]
+func (q *Query) Between(field string, min, max interface{}) *Query {
+	return q.Gte(field, min).Lte(field, max)
+}
+
+// Regex matches documents where field matches the regular expression pattern
+// opts are the mongo regex options e.g "i" for case insensitive matching
+func (q *Query) Regex(field, pattern, opts string) *Query {
+	return q.op(field, "$regex", primitive.Regex{Pattern: pattern, Options: opts})
+}
+
+// Exists matches documents that have (or do not have) field
+func (q *Query) Exists(field string, exists bool) *Query {
+	return q.op(field, "$exists", exists)
+}
+
+// And matches documents that match all the sub queries
+func (q *Query) And(qs ...*Query) *Query {
+	return q.logic("$and", qs)
+}
+
+// Or matches documents that match at least one of the sub queries
+func (q *Query) Or(qs ...*Query) *Query {
+	return q.logic("$or", qs)
+}
+
+// Sort orders the results by field. Calls are cumulative, the first field sorted on takes precedence
+func (q *Query) Sort(field string, order SortOrder) *Query {
+	q.sort = append(q.sort, bson.E{Key: field, Value: int(order)})
+	return q
+}
+
+// Project restricts the fields returned to the given fields
+func (q *Query) Project(fields ...string) *Query {
+	for _, f := range fields {
+		q.projection = append(q.projection, bson.E{Key: f, Value: 1})
+	}
+	return q
+}
+
+// Exclude removes the given fields from the returned documents
+func (q *Query) Exclude(fields ...string) *Query {
+	for _, f := range fields {
+		q.projection = append(q.projection, bson.E{Key: f, Value: 0})
+	}
+	return q
+}
+
+// Limit restricts the number of documents returned
+func (q *Query) Limit(n int64) *Query {
+	q.limit = n
+	return q
+}
+
+// Skip skips the first n matching documents
+func (q *Query) Skip(n int64) *Query {
+	q.skip = n
+	return q
+}
+
+// Filter returns the filter document built by the query
+func (q *Query) Filter() bson.D {
+	f := bson.D{}
+	for _, e := range q.filter {
+		if ops, ok := e.Value.(operators); ok {
+			e.Value = bson.D(ops)
+		}
+		f = append(f, e)
+	}
+	switch len(q.logical) {
+	case 0:
+	case 1:
+		f = append(f, q.logical[0])
+	default:
+		all := bson.A{}
+		for _, e := range q.logical {
+			all = append(all, bson.D{e})
+		}
+		f = append(f, bson.E{Key: "$and", Value: all})
+	}
+	return f
+}
+
+// MarshalBSON marshals the query into its filter document
+func (q *Query) MarshalBSON() ([]byte, error) {
+	return bson.Marshal(q.Filter())
+}
+
+// FindOptions returns the sort, projection, skip and limit of the query as find options
+func (q *Query) FindOptions() *options.FindOptions {
+	opts := options.Find()
+	if len(q.sort) > 0 {
+		opts.SetSort(q.sort)
+	}
+	if len(q.projection) > 0 {
+		opts.SetProjection(q.projection)
+	}
+	if q.limit > 0 {
+		opts.SetLimit(q.limit)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	return opts
+}
+
+// FindOneOptions returns the sort, projection and skip of the query as find one options
+func (q *Query) FindOneOptions() *options.FindOneOptions {
+	opts := options.FindOne()
+	if len(q.sort) > 0 {
+		opts.SetSort(q.sort)
+	}
+	if len(q.projection) > 0 {
+		opts.SetProjection(q.projection)
+	}
+	if q.skip > 0 {
+		opts.SetSkip(q.skip)
+	}
+	return opts
+}
+
+// PageOptions returns the sort, projection, skip and limit of the query as page options
+func (q *Query) PageOptions() PageOptions {
+	opts := PageOptions{Skip: q.skip, Limit: q.limit}
+	if len(q.sort) > 0 {
+		opts.Sort = q.sort
+	}
+	if len(q.projection) > 0 {
+		opts.Projection = q.projection
+	}
+	return opts
+}
+
+func (q *Query) op(field, op string, v interface{}) *Query {
+	for i, e := range q.filter {
+		if e.Key != field {
+			continue
+		}
+		ops, ok := e.Value.(operators)
+		if !ok {
+			// an equality condition is replaced by the operator condition
+			ops = operators{}
+		}
+		q.filter[i].Value = setOperator(ops, op, v)
+		return q
+	}
+	q.filter = append(q.filter, bson.E{Key: field, Value: operators{{Key: op, Value: v}}})
+	return q
+}
+
+func (q *Query) logic(op string, qs []*Query) *Query {
+	clauses := bson.A{}
+	for _, sub := range qs {
+		clauses = append(clauses, sub.Filter())
+	}
+	q.logical = append(q.logical, bson.E{Key: op, Value: clauses})
+	return q
+}
+
+func setOperator(ops operators, op string, v interface{}) operators {
+	for i, e := range ops {
+		if e.Key == op {
+			ops[i].Value = v
+			return ops
+		}
+	}
+	return append(ops, bson.E{Key: op, Value: v})
+}
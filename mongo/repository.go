@@ -0,0 +1,142 @@
+package mongo
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Repository is a typed view of a collection whose documents decode into T
+type Repository[T any] struct {
+	col Collection
+}
+
+// NewRepository creates a new repository for the documents of a collection
+func NewRepository[T any](provideDB DBProviderFunc, cn string) Repository[T] {
+	return Repository[T]{col: NewCollection(provideDB, cn)}
+}
+
+// RepositoryFor creates a repository on top of an existing collection
+func RepositoryFor[T any](c Collection) Repository[T] {
+	return Repository[T]{col: c}
+}
+
+// Collection returns the collection the repository is built on
+func (r Repository[T]) Collection() Collection {
+	return r.col
+}
+
+// Get returns the document with the given id
+func (r Repository[T]) Get(ctx context.Context, id string) (T, error) {
+	var doc T
+	err := r.col.WithContext(ctx).FindByID(id, &doc)
+	return doc, err
+}
+
+// List returns every document matching the filter. A nil filter matches all documents
+func (r Repository[T]) List(ctx context.Context, filter interface{}) ([]T, error) {
+	it, err := r.Iter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	docs := []T{}
+	for it.Next() {
+		doc, err := it.Value()
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, it.Err()
+}
+
+// Iter returns an iterator over the documents matching the filter. A nil filter matches all documents
+// The caller must Close the iterator when done
+func (r Repository[T]) Iter(ctx context.Context, filter interface{}) (*Iterator[T], error) {
+	scoped, err := r.col.scope(filter)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := r.col.col.Find(ctx, scoped)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator[T]{cur: cur, ctx: ctx, col: r.col}, nil
+}
+
+// Insert adds a new document to the collection
+func (r Repository[T]) Insert(ctx context.Context, doc T) error {
+	_, err := r.col.WithContext(ctx).InsertOneDoc(doc)
+	return err
+}
+
+// Replace replaces the document with the given id
+// mongo.ErrNoDocuments is returned when no document has the id
+func (r Repository[T]) Replace(ctx context.Context, id string, doc T) error {
+	res, err := r.col.WithContext(ctx).Replace(id, doc)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Patch sets the given fields on the document with the given id
+// mongo.ErrNoDocuments is returned when no document has the id
+func (r Repository[T]) Patch(ctx context.Context, id string, changes interface{}) error {
+	filter, err := r.col.scope(bson.M{"id": id})
+	if err != nil {
+		return err
+	}
+	changes, err = r.col.stampSet(changes)
+	if err != nil {
+		return err
+	}
+	res, err := r.col.col.UpdateOne(ctx, filter, r.col.updateDoc(changes))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete deletes the document with the given id
+func (r Repository[T]) Delete(ctx context.Context, id string) error {
+	return r.col.WithContext(ctx).Delete(id)
+}
+
+// Iterator iterates over documents of type T returned by a query
+type Iterator[T any] struct {
+	cur *mongo.Cursor
+	ctx context.Context
+	col Collection
+}
+
+// Next advances the iterator, returning false once there are no more documents or an error occurred
+func (it *Iterator[T]) Next() bool {
+	return it.cur.Next(it.ctx)
+}
+
+// Value decodes the current document
+func (it *Iterator[T]) Value() (T, error) {
+	var doc T
+	err := it.col.decodeRaw(it.cur.Current, &doc)
+	return doc, err
+}
+
+// Err returns the last error seen by the iterator
+func (it *Iterator[T]) Err() error {
+	return it.cur.Err()
+}
+
+// Close closes the underlying cursor
+func (it *Iterator[T]) Close() error {
+	return it.cur.Close(it.ctx)
+}
@@ -0,0 +1,128 @@
+package mongo
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	geoIndexType = "2dsphere"
+	// TextScoreField is the field the relevance of a text search is returned in, see Query.SortByTextScore
+	TextScoreField = "score"
+	// earthRadiusMeters converts distances to the radians expected by $centerSphere
+	earthRadiusMeters = 6378100.0
+)
+
+// Point is a GeoJSON point. Store it in documents to query them with Near and Within conditions
+type Point struct {
+	Type string `bson:"type" json:"type"`
+	// Coordinates are the longitude and latitude, in that order
+	Coordinates []float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewPoint creates a GeoJSON point. Note the longitude comes first
+func NewPoint(lng, lat float64) Point {
+	return Point{Type: "Point", Coordinates: []float64{lng, lat}}
+}
+
+// Lng returns the longitude of the point
+func (p Point) Lng() float64 {
+	if len(p.Coordinates) < 1 {
+		return 0
+	}
+	return p.Coordinates[0]
+}
+
+// Lat returns the latitude of the point
+func (p Point) Lat() float64 {
+	if len(p.Coordinates) < 2 {
+		return 0
+	}
+	return p.Coordinates[1]
+}
+
+// Polygon is a GeoJSON polygon with a single ring
+type Polygon struct {
+	Type        string        `bson:"type" json:"type"`
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"`
+}
+
+// NewPolygon creates a GeoJSON polygon from its corners. The ring is closed when the last corner is not the first
+func NewPolygon(corners ...Point) Polygon {
+	ring := [][]float64{}
+	for _, c := range corners {
+		ring = append(ring, []float64{c.Lng(), c.Lat()})
+	}
+	if len(corners) > 0 && (corners[0].Lng() != corners[len(corners)-1].Lng() || corners[0].Lat() != corners[len(corners)-1].Lat()) {
+		ring = append(ring, []float64{corners[0].Lng(), corners[0].Lat()})
+	}
+	return Polygon{Type: "Polygon", Coordinates: [][][]float64{ring}}
+}
+
+// GeoIndexOn declares the 2dsphere index Near and Within conditions on field need
+func GeoIndexOn(field string) IndexSpec {
+	return IndexSpec{Keys: bson.D{{Key: field, Value: geoIndexType}}}
+}
+
+// Text matches documents whose text indexed fields contain the words of search, see TextIndexOn
+// Words are or'ed, "quoted phrases" must all match and -words exclude documents
+func (q *Query) Text(search string) *Query {
+	return q.Eq("$text", bson.D{{Key: "$search", Value: search}})
+}
+
+// SortByTextScore orders the results of a Text query by relevance, most relevant first.
+// The relevance is returned in the TextScoreField of the documents
+func (q *Query) SortByTextScore() *Query {
+	score := bson.D{{Key: "$meta", Value: "textScore"}}
+	q.projection = append(q.projection, bson.E{Key: TextScoreField, Value: score})
+	q.sort = append(q.sort, bson.E{Key: TextScoreField, Value: score})
+	return q
+}
+
+// Near matches documents whose point in field is within maxMeters of p, nearest first. maxMeters is ignored when 0
+// A Near query can not be counted, so can not be used with FindPage
+func (q *Query) Near(field string, p Point, maxMeters float64) *Query {
+	near := bson.D{{Key: "$geometry", Value: p}}
+	if maxMeters > 0 {
+		near = append(near, bson.E{Key: "$maxDistance", Value: maxMeters})
+	}
+	return q.op(field, "$near", near)
+}
+
+// WithinPolygon matches documents whose point in field is inside the polygon
+func (q *Query) WithinPolygon(field string, polygon Polygon) *Query {
+	return q.op(field, "$geoWithin", bson.D{{Key: "$geometry", Value: polygon}})
+}
+
+// WithinRadius matches documents whose point in field is within meters of center, in no particular order
+func (q *Query) WithinRadius(field string, center Point, meters float64) *Query {
+	sphere := bson.A{bson.A{center.Lng(), center.Lat()}, meters / earthRadiusMeters}
+	return q.op(field, "$geoWithin", bson.D{{Key: "$centerSphere", Value: sphere}})
+}
+
+// GeoNear starts the pipeline with the documents whose point in field is within maxMeters of p, nearest first,
+// setting their distance in meters in distanceField. maxMeters is ignored when 0
+func (p *Pipeline) GeoNear(field string, near Point, distanceField string, maxMeters float64) *Pipeline {
+	stage := bson.D{
+		{Key: "near", Value: near},
+		{Key: "key", Value: field},
+		{Key: "distanceField", Value: distanceField},
+		{Key: "spherical", Value: true},
+	}
+	if maxMeters > 0 {
+		stage = append(stage, bson.E{Key: "maxDistance", Value: maxMeters})
+	}
+	return p.Stage("$geoNear", stage)
+}
+
+// SearchText returns the documents matching a text search, most relevant first
+// the 'onEach' function is called for each match as the cursor iterates
+func (c Collection) SearchText(search string, limit int64, onEach func(c *mongo.Cursor) error) error {
+	return c.FindWithQuery(NewQuery().Text(search).SortByTextScore().Limit(limit), onEach)
+}
+
+// FindNear returns the documents whose point in field is within maxMeters of p, nearest first
+// the 'onEach' function is called for each match as the cursor iterates
+func (c Collection) FindNear(field string, p Point, maxMeters float64, limit int64, onEach func(c *mongo.Cursor) error) error {
+	return c.FindWithQuery(NewQuery().Near(field, p, maxMeters).Limit(limit), onEach)
+}
@@ -0,0 +1,157 @@
+package mongo
+
+import (
+	"context"
+	"regexp"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	maxTenantIDLength = 38
+	maxDBNameLength   = 63
+	// tenantSeparator joins the tenant id to the base database name or to collection names.
+	// Tenant ids can not contain it, so two tenants never resolve to the same name
+	tenantSeparator = "_"
+)
+
+// validTenantID is lowercase only, as mongo database names must differ by more than case
+var validTenantID = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// ErrNoTenant is returned when a context carries no tenant id, see WithTenant
+var ErrNoTenant = errors.New("no tenant in context")
+
+// tenantKey is the context key of the tenant id
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx carrying the tenant id
+// Tenant ids are made of lowercase letters, digits and -, at most 38 of them
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant id carried by ctx
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// TenantIsolation is how the data of tenants is kept apart
+type TenantIsolation int
+
+const (
+	// DatabasePerTenant stores each tenant in its own database, named <base>_<tenant>
+	DatabasePerTenant TenantIsolation = iota
+	// CollectionPrefixPerTenant stores every tenant in the base database, prefixing collection names with <tenant>_
+	CollectionPrefixPerTenant
+)
+
+// TenantProvider resolves the database of the tenant carried by a context
+type TenantProvider struct {
+	client    *mongo.Client
+	base      string
+	isolation TenantIsolation
+
+	mu  sync.RWMutex
+	dbs map[string]*mongo.Database
+}
+
+// NewTenantProvider creates a tenant provider on client. base is the database name prefix with DatabasePerTenant,
+// and the shared database with CollectionPrefixPerTenant
+func NewTenantProvider(client *mongo.Client, base string, isolation TenantIsolation) (*TenantProvider, error) {
+	maxBase := maxDBNameLength
+	if isolation == DatabasePerTenant {
+		// room is left for the separator and the longest tenant id
+		maxBase -= len(tenantSeparator) + maxTenantIDLength
+	}
+	if base == "" || len(base) > maxBase {
+		return nil, errors.Errorf("tenant base database name %q must be 1 to %d bytes long", base, maxBase)
+	}
+	return &TenantProvider{client: client, base: base, isolation: isolation, dbs: map[string]*mongo.Database{}}, nil
+}
+
+// ToTenantProvider returns a tenant provider from the config, DBName being the base of the tenant databases
+// The returned disconnect takes a context and returns its error, e.g to register with a lifecycle.Manager
+func (c DBConfig) ToTenantProvider(isolation TenantIsolation) (*TenantProvider, DisconnectFunc, error) {
+	mClient, err := c.connect()
+	if err != nil {
+		return nil, nil, err
+	}
+	p, err := NewTenantProvider(mClient, c.DBName, isolation)
+	if err != nil {
+		mClient.Disconnect(context.Background())
+		return nil, nil, err
+	}
+	return p, disconnectFunc(mClient), nil
+}
+
+// Database returns the database of the tenant carried by ctx
+func (p *TenantProvider) Database(ctx context.Context) (*mongo.Database, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.database(tenantID), nil
+}
+
+// ForContext returns a provider of the database of the tenant carried by ctx, to use with the functions taking a
+// DBProviderFunc. With CollectionPrefixPerTenant the provider returns the shared database, use NewCollection
+// to get collections scoped to the tenant
+func (p *TenantProvider) ForContext(ctx context.Context) (DBProviderFunc, error) {
+	db, err := p.Database(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return func() *mongo.Database {
+		return db
+	}, nil
+}
+
+// NewCollection creates a collection scoped to the tenant carried by ctx, whose operations run with ctx
+func (p *TenantProvider) NewCollection(ctx context.Context, cn string) (Collection, error) {
+	tenantID, err := tenantOf(ctx)
+	if err != nil {
+		return Collection{}, err
+	}
+	if p.isolation == CollectionPrefixPerTenant {
+		cn = tenantID + tenantSeparator + cn
+	}
+	return Collection{col: p.database(tenantID).Collection(cn), ctx: ctx}, nil
+}
+
+// database returns the cached database handle of a tenant, creating it on first use
+func (p *TenantProvider) database(tenantID string) *mongo.Database {
+	p.mu.RLock()
+	db, ok := p.dbs[tenantID]
+	p.mu.RUnlock()
+	if ok {
+		return db
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if db, ok := p.dbs[tenantID]; ok {
+		return db
+	}
+	name := p.base
+	if p.isolation == DatabasePerTenant {
+		name = p.base + tenantSeparator + tenantID
+	}
+	db = p.client.Database(name)
+	p.dbs[tenantID] = db
+	return db
+}
+
+// tenantOf returns the tenant id carried by ctx, checking it can be used in database and collection names
+func tenantOf(ctx context.Context) (string, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return "", ErrNoTenant
+	}
+	if len(tenantID) > maxTenantIDLength || !validTenantID.MatchString(tenantID) {
+		return "", errors.Errorf("tenant id %q must be at most %d lowercase letters, digits or -", tenantID, maxTenantIDLength)
+	}
+	return tenantID, nil
+}
@@ -0,0 +1,64 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestTenantDatabaseNames(t *testing.T) {
+	// the client does not reach the server until an operation runs
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://localhost:27017"))
+	if err != nil {
+		t.Fatalf("Unable to create client: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	p, err := NewTenantProvider(client, "base", DatabasePerTenant)
+	if err != nil {
+		t.Fatalf("Unable to create tenant provider: %v", err)
+	}
+
+	tests := []struct {
+		tenantID string
+		want     string
+	}{
+		{"acme", "base_acme"},
+		{"acme-2", "base_acme-2"},
+		// ids differing by case only would name databases mongo considers the same
+		{"Acme", ""},
+		{"acme_2", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		db, err := p.Database(WithTenant(context.Background(), tt.tenantID))
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Tenant %q resolved to database %s, want an error", tt.tenantID, db.Name())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unable to resolve tenant %q: %v", tt.tenantID, err)
+			continue
+		}
+		if db.Name() != tt.want {
+			t.Errorf("Tenant %q resolved to database %s, want %s", tt.tenantID, db.Name(), tt.want)
+		}
+	}
+}
+
+func TestTenantBaseLength(t *testing.T) {
+	long := "abcdefghijklmnopqrstuvwxyz"
+	if _, err := NewTenantProvider(nil, long, DatabasePerTenant); err == nil {
+		t.Errorf("Base %q was accepted, leaving no room for tenant ids", long)
+	}
+	if _, err := NewTenantProvider(nil, long, CollectionPrefixPerTenant); err != nil {
+		t.Errorf("Unable to create tenant provider sharing database %q: %v", long, err)
+	}
+	if _, err := NewTenantProvider(nil, "", CollectionPrefixPerTenant); err == nil {
+		t.Error("Empty base was accepted")
+	}
+}
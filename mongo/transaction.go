@@ -0,0 +1,34 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TxFunc is the work done within a transaction
+// Collection operations take part in the transaction when run with txCtx, see Collection.WithContext
+type TxFunc func(txCtx context.Context) error
+
+// RunInTransaction runs fn within a transaction on the database's client
+// The transaction is committed when fn returns nil and aborted when it returns an error.
+// fn is retried when the transaction or its commit fails with a transient error, so it must be safe to call more than once
+func RunInTransaction(ctx context.Context, provideDB DBProviderFunc, fn TxFunc, opts ...*options.TransactionOptions) error {
+	sess, err := provideDB().Client().StartSession()
+	if err != nil {
+		return errors.Wrap(err, "unable to start mongo session")
+	}
+	defer sess.EndSession(ctx)
+
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	}, opts...)
+	return err
+}
+
+// IsInTransaction checks if a context is running within a transaction started by RunInTransaction
+func IsInTransaction(ctx context.Context) bool {
+	return mongo.SessionFromContext(ctx) != nil
+}
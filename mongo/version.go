@@ -0,0 +1,97 @@
+package mongo
+
+import (
+	"fmt"
+
+	"github.com/babyfaceEasy/commons/commonerror"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultVersionField = "version"
+	initialVersion      = int64(1)
+)
+
+// ErrNotVersioned is returned by versioned writes on a collection without WithVersioning, whose documents are never
+// given a version to compare
+var ErrNotVersioned = errors.New("collection is not versioned, see WithVersioning")
+
+// WithVersioning returns a copy of the collection that keeps a version number in field, "version" by default
+// Inserts start documents at version 1 and every write increments it.
+// Use ReplaceIfVersion and UpdateIfVersion to only write documents that have not changed since they were read
+func (c Collection) WithVersioning(field string) Collection {
+	if field == "" {
+		field = defaultVersionField
+	}
+	c.versionField = field
+	return c
+}
+
+// ReplaceIfVersion replaces the document with the given ID if it is still at version
+// A commonerror conflict error is returned when the document is at another version, mongo.ErrNoDocuments when there
+// is no document with the ID, and ErrNotVersioned when the collection is not versioned
+func (c Collection) ReplaceIfVersion(ID string, version int64, replacement interface{}) error {
+	if c.versionField == "" {
+		return ErrNotVersioned
+	}
+	filter, err := c.versionFilter(ID, version)
+	if err != nil {
+		return err
+	}
+	res, err := c.replaceOne(filter, replacement, false)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return c.versionMismatch(ID, version)
+	}
+	return nil
+}
+
+// UpdateIfVersion sets the changes on the document with the given ID if it is still at version
+// A commonerror conflict error is returned when the document is at another version, mongo.ErrNoDocuments when there
+// is no document with the ID, and ErrNotVersioned when the collection is not versioned
+func (c Collection) UpdateIfVersion(ID string, version int64, changes interface{}) error {
+	if c.versionField == "" {
+		return ErrNotVersioned
+	}
+	filter, err := c.versionFilter(ID, version)
+	if err != nil {
+		return err
+	}
+	changes, err = c.stampSet(changes)
+	if err != nil {
+		return err
+	}
+	res, err := c.col.UpdateOne(c.getContext(), filter, c.updateDoc(changes))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return c.versionMismatch(ID, version)
+	}
+	return nil
+}
+
+// versionFilter matches the document with the given ID at version
+func (c Collection) versionFilter(ID string, version int64) (interface{}, error) {
+	return c.scope(bson.M{"id": ID, c.versionField: version})
+}
+
+// versionMismatch tells apart a missing document from one at another version, after a versioned write matched nothing
+func (c Collection) versionMismatch(ID string, version int64) error {
+	filter, err := c.scope(bson.M{"id": ID})
+	if err != nil {
+		return err
+	}
+	n, err := c.col.CountDocuments(c.getContext(), filter)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return commonerror.NewErrorParams(c.versionField, fmt.Sprintf("Document %s is not at version %d", ID, version)).ToConflict()
+}
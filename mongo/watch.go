@@ -0,0 +1,353 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	resumeTokensCollection = "resume_tokens"
+	defaultWatchRetryDelay = 5 * time.Second
+)
+
+// errors of a change stream that can not resume from its token, e.g the oplog no longer holds it
+var nonResumableCodes = []int{
+	260, // InvalidResumeToken
+	280, // ChangeStreamFatalError
+	286, // ChangeStreamHistoryLost
+}
+
+// OperationType is the kind of change of a change event
+type OperationType string
+
+const (
+	// OperationInsert is the insertion of a new document
+	OperationInsert OperationType = "insert"
+	// OperationUpdate is an update of some fields of a document
+	OperationUpdate OperationType = "update"
+	// OperationReplace is the replacement of a whole document
+	OperationReplace OperationType = "replace"
+	// OperationDelete is the removal of a document
+	OperationDelete OperationType = "delete"
+
+	// operationInvalidate ends a stream once its collection is dropped or renamed
+	operationInvalidate = "invalidate"
+)
+
+// ChangeEvent is a change to a document of a watched collection
+type ChangeEvent struct {
+	Operation  OperationType
+	DocumentID interface{}
+	// FullDocument is the document after an insert or replace, and after an update when WatchOptions.FullDocument is set
+	FullDocument  bson.Raw
+	UpdatedFields bson.Raw
+	RemovedFields []string
+	ClusterTime   primitive.Timestamp
+	ResumeToken   bson.Raw
+}
+
+// Decode decodes the full document of the event
+func (e ChangeEvent) Decode(v interface{}) error {
+	if e.FullDocument == nil {
+		return mongo.ErrNoDocuments
+	}
+	return bson.Unmarshal(e.FullDocument, v)
+}
+
+// changeDoc is a change stream document as sent by mongo
+type changeDoc struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID interface{} `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument      bson.Raw `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields bson.Raw `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+	ClusterTime primitive.Timestamp `bson:"clusterTime"`
+}
+
+// ResumeTokenStore persists the position of a change stream so it can resume after a restart
+type ResumeTokenStore interface {
+	// Load returns the last saved token for key, or nil when there is none
+	Load(ctx context.Context, key string) (bson.Raw, error)
+	// Save saves the token for key. A nil token clears it
+	Save(ctx context.Context, key string, token bson.Raw) error
+}
+
+// WatchOptions configures a change stream
+type WatchOptions struct {
+	// Operations restricts the events to these kinds of change. All kinds are delivered when empty
+	Operations []OperationType
+	// Filter matches change events e.g bson.M{"fullDocument.status": "paid"}
+	Filter interface{}
+	// FullDocument looks up the current document for update events
+	FullDocument bool
+	// Store persists resume tokens. The stream starts from now when nil
+	Store ResumeTokenStore
+	// Key identifies the stream in Store, it defaults to the collection name
+	Key string
+	// RetryDelay is how long to wait before reopening a failed stream, 5 seconds by default
+	RetryDelay time.Duration
+}
+
+// Watch opens a change stream on the collection, calling onEvent for each change
+// The resume token of each event is saved once onEvent returns, and the stream is reopened from the last
+// token when it fails. When the token can no longer be resumed from, it is cleared and the stream restarts from now,
+// skipping the changes in between. When the collection is dropped or renamed the stream is reopened after the
+// invalidate event, so it sees the changes of a collection created under the same name.
+// Watch blocks until the collection's context is done or onEvent returns an error
+func (c Collection) Watch(opts WatchOptions, onEvent func(e ChangeEvent) error) error {
+	ctx := c.getContext()
+	if opts.Key == "" {
+		opts.Key = c.col.Name()
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultWatchRetryDelay
+	}
+
+	var (
+		token bson.Raw
+		// startAfter is set after an invalidate event, whose token the stream can only start after, not resume after
+		startAfter bool
+	)
+	if opts.Store != nil {
+		t, err := opts.Store.Load(ctx, opts.Key)
+		if err != nil {
+			return errors.Wrapf(err, "unable to load resume token for stream=%s", opts.Key)
+		}
+		token = t
+	}
+
+	for {
+		err := c.watchOnce(ctx, opts, token, startAfter, func(e ChangeEvent) error {
+			if err := onEvent(e); err != nil {
+				return &handlerError{err: err}
+			}
+			token, startAfter = e.ResumeToken, false
+			if opts.Store != nil {
+				if err := opts.Store.Save(ctx, opts.Key, token); err != nil {
+					log.Println("Unable to save resume token with error: ", err)
+				}
+			}
+			return nil
+		})
+
+		var he *handlerError
+		if errors.As(err, &he) {
+			return he.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var ie *invalidatedError
+		if errors.As(err, &ie) {
+			log.Printf("Change stream=%s was invalidated as the collection was dropped or renamed, reopening", opts.Key)
+			token, startAfter = ie.token, true
+			continue
+		}
+		if isNonResumable(err) {
+			if token == nil {
+				return err
+			}
+			// the changes since the token are lost, so the stream restarts from now rather than failing forever
+			log.Printf("Change stream=%s can not resume, restarting from now with error: %v", opts.Key, err)
+			token, startAfter = nil, false
+			if opts.Store != nil {
+				if err := opts.Store.Save(ctx, opts.Key, nil); err != nil {
+					log.Println("Unable to clear resume token with error: ", err)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			log.Println("Change stream closed, reopening with error: ", err)
+		} else {
+			log.Printf("Change stream=%s closed, reopening", opts.Key)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(opts.RetryDelay):
+		}
+	}
+}
+
+// WatchChan opens a change stream like Watch, delivering its events on a channel
+// The error channel receives the error that ended the stream, after which both channels are closed
+func (c Collection) WatchChan(opts WatchOptions) (<-chan ChangeEvent, <-chan error) {
+	events := make(chan ChangeEvent)
+	errs := make(chan error, 1)
+	ctx := c.getContext()
+
+	go func() {
+		defer close(events)
+		defer close(errs)
+		errs <- c.Watch(opts, func(e ChangeEvent) error {
+			select {
+			case events <- e:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return events, errs
+}
+
+func (c Collection) watchOnce(ctx context.Context, opts WatchOptions, token bson.Raw, startAfter bool, onEvent func(e ChangeEvent) error) error {
+	streamOpts := options.ChangeStream()
+	if opts.FullDocument {
+		streamOpts.SetFullDocument(options.UpdateLookup)
+	}
+	switch {
+	case token != nil && startAfter:
+		streamOpts.SetStartAfter(token)
+	case token != nil:
+		streamOpts.SetResumeAfter(token)
+	}
+
+	stream, err := c.col.Watch(ctx, watchPipeline(opts), streamOpts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var doc changeDoc
+		if err := stream.Decode(&doc); err != nil {
+			return err
+		}
+		if doc.OperationType == operationInvalidate {
+			return &invalidatedError{token: append(bson.Raw{}, stream.ResumeToken()...)}
+		}
+		e := ChangeEvent{
+			Operation:     OperationType(doc.OperationType),
+			DocumentID:    doc.DocumentKey.ID,
+			FullDocument:  doc.FullDocument,
+			UpdatedFields: doc.UpdateDescription.UpdatedFields,
+			RemovedFields: doc.UpdateDescription.RemovedFields,
+			ClusterTime:   doc.ClusterTime,
+			ResumeToken:   append(bson.Raw{}, stream.ResumeToken()...),
+		}
+		if err := onEvent(e); err != nil {
+			return err
+		}
+	}
+	return stream.Err()
+}
+
+func watchPipeline(opts WatchOptions) mongo.Pipeline {
+	pipeline := mongo.Pipeline{}
+	if len(opts.Operations) > 0 {
+		ops := bson.A{}
+		for _, op := range opts.Operations {
+			ops = append(ops, string(op))
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": ops}}}})
+	}
+	if opts.Filter != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: opts.Filter}})
+	}
+	return pipeline
+}
+
+// isNonResumable tells whether a change stream failed because it can not resume from its token
+func isNonResumable(err error) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, code := range nonResumableCodes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidatedError ends a stream whose collection was dropped or renamed, holding the token to start the next one after
+type invalidatedError struct {
+	token bson.Raw
+}
+
+func (e *invalidatedError) Error() string {
+	return "change stream invalidated"
+}
+
+// handlerError marks an error returned by the caller's event handler, which ends the stream instead of reopening it
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// MongoTokenStore saves resume tokens in a collection of the database
+type MongoTokenStore struct {
+	provideDB DBProviderFunc
+}
+
+// NewMongoTokenStore creates a resume token store in the "resume_tokens" collection
+func NewMongoTokenStore(provideDB DBProviderFunc) MongoTokenStore {
+	return MongoTokenStore{provideDB: provideDB}
+}
+
+// Load returns the last saved token for key, or nil when there is none
+func (s MongoTokenStore) Load(ctx context.Context, key string) (bson.Raw, error) {
+	var doc struct {
+		Token bson.Raw `bson:"token"`
+	}
+	err := s.provideDB().Collection(resumeTokensCollection).FindOne(ctx, bson.M{"_id": key}).Decode(&doc)
+	if IsNotFoundError(err) {
+		return nil, nil
+	}
+	return doc.Token, err
+}
+
+// Save saves the token for key, clearing it when token is nil
+func (s MongoTokenStore) Save(ctx context.Context, key string, token bson.Raw) error {
+	update := bson.M{"$set": bson.M{"token": token, "updatedAt": time.Now().UTC()}}
+	if token == nil {
+		update = bson.M{"$set": bson.M{"updatedAt": time.Now().UTC()}, "$unset": bson.M{"token": ""}}
+	}
+	_, err := s.provideDB().Collection(resumeTokensCollection).UpdateOne(ctx, bson.M{"_id": key}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// MemoryTokenStore keeps resume tokens in memory, so streams resume after reconnects but not after restarts
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]bson.Raw
+}
+
+// NewMemoryTokenStore creates an in memory resume token store
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]bson.Raw{}}
+}
+
+// Load returns the last saved token for key, or nil when there is none
+func (s *MemoryTokenStore) Load(ctx context.Context, key string) (bson.Raw, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[key], nil
+}
+
+// Save saves the token for key
+func (s *MemoryTokenStore) Save(ctx context.Context, key string, token bson.Raw) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+	return nil
+}
@@ -0,0 +1,311 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/babyfaceEasy/commons/fcm"
+	"github.com/babyfaceEasy/commons/mongo"
+	"github.com/babyfaceEasy/commons/twilio"
+	"github.com/babyfaceEasy/commons/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	driver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	outboxCollection    = "outbox"
+	defaultBatchSize    = 50
+	defaultPollInterval = 5 * time.Second
+	defaultMaxAttempts  = 5
+	defaultBackoff      = 30 * time.Second
+	defaultClaimTTL     = 2 * time.Minute
+	maxBackoff          = 24 * time.Hour
+)
+
+var registerIndexes sync.Once
+
+// Channel is how a message is delivered
+type Channel string
+
+const (
+	// ChannelSMS delivers a text message with twilio.Client.SendSMS
+	ChannelSMS Channel = "sms"
+	// ChannelPush delivers a push notification with fcm.Client.NotifyDevice
+	ChannelPush Channel = "push"
+)
+
+// Status is the delivery state of a message
+type Status string
+
+const (
+	// StatusPending messages are waiting to be sent, or to be retried
+	StatusPending Status = "pending"
+	// StatusProcessing messages are claimed by a relay
+	StatusProcessing Status = "processing"
+	// StatusSent messages were accepted by the provider
+	StatusSent Status = "sent"
+	// StatusFailed messages failed on every attempt and will not be retried
+	StatusFailed Status = "failed"
+)
+
+// Message is a notification intent stored in the outbox
+type Message struct {
+	ID      string  `bson:"id" json:"id"`
+	Channel Channel `bson:"channel" json:"channel"`
+	// To is the phone number of an sms, or the device id of a push notification
+	To    string                 `bson:"to" json:"to"`
+	Title string                 `bson:"title,omitempty" json:"title,omitempty"`
+	Body  string                 `bson:"body,omitempty" json:"body,omitempty"`
+	Data  map[string]interface{} `bson:"data,omitempty" json:"data,omitempty"`
+
+	Status        Status    `bson:"status" json:"status"`
+	Attempts      int       `bson:"attempts" json:"attempts"`
+	LastError     string    `bson:"lastError,omitempty" json:"lastError,omitempty"`
+	ProviderID    string    `bson:"providerId,omitempty" json:"providerId,omitempty"`
+	NextAttemptAt time.Time `bson:"nextAttemptAt" json:"nextAttemptAt"`
+	ClaimedBy     string    `bson:"claimedBy,omitempty" json:"-"`
+	ClaimedUntil  time.Time `bson:"claimedUntil,omitempty" json:"-"`
+	CreatedAt     time.Time `bson:"createdAt" json:"createdAt"`
+	SentAt        time.Time `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+}
+
+// Outbox stores notification intents alongside business data, for a Relay to deliver
+type Outbox struct {
+	provideDB mongo.DBProviderFunc
+}
+
+// New creates an outbox in the "outbox" collection. Its indexes are created by mongo.EnsureIndexes
+func New(provideDB mongo.DBProviderFunc) Outbox {
+	registerIndexes.Do(func() {
+		mongo.RegisterIndexes(outboxCollection, mongo.IndexOn("status", "nextAttemptAt"))
+	})
+	return Outbox{provideDB: provideDB}
+}
+
+// EnqueueSMS stores an sms to send. Pass in the txCtx of mongo.RunInTransaction so the message is only stored,
+// and so only sent, when the business data written in the same transaction is committed
+func (o Outbox) EnqueueSMS(ctx context.Context, to, body string) (string, error) {
+	return o.enqueue(ctx, Message{Channel: ChannelSMS, To: to, Body: body})
+}
+
+// EnqueuePush stores a push notification to send, see EnqueueSMS
+func (o Outbox) EnqueuePush(ctx context.Context, deviceID, title string, data map[string]interface{}) (string, error) {
+	return o.enqueue(ctx, Message{Channel: ChannelPush, To: deviceID, Title: title, Data: data})
+}
+
+func (o Outbox) enqueue(ctx context.Context, m Message) (string, error) {
+	now := time.Now().UTC()
+	m.ID = string(uuid.GenV4())
+	m.Status = StatusPending
+	m.CreatedAt = now
+	m.NextAttemptAt = now
+	if _, err := o.collection().InsertOne(ctx, m); err != nil {
+		return "", errors.Wrapf(err, "unable to store %s message to %s", m.Channel, m.To)
+	}
+	return m.ID, nil
+}
+
+// Get returns the message with the given id, e.g to check its delivery outcome
+func (o Outbox) Get(ctx context.Context, id string) (Message, error) {
+	var m Message
+	err := o.collection().FindOne(ctx, bson.M{"id": id}).Decode(&m)
+	return m, err
+}
+
+func (o Outbox) collection() *driver.Collection {
+	return o.provideDB().Collection(outboxCollection)
+}
+
+// SMSSender sends text messages, it is implemented by twilio.Client
+type SMSSender interface {
+	SendSMS(to, body string) (twilio.SmsResponse, error)
+}
+
+// PushSender sends push notifications, it is implemented by *fcm.Client
+type PushSender interface {
+	NotifyDevice(deviceId string, optionalData map[string]interface{}, messageTitle string) (fcm.Response, error)
+}
+
+// RelayOptions configures a relay. Zero values take the defaults
+type RelayOptions struct {
+	// BatchSize is the most messages claimed per poll, 50 by default
+	BatchSize int
+	// PollInterval is the wait between polls when the outbox is empty, 5 seconds by default
+	PollInterval time.Duration
+	// MaxAttempts is how many times a message is tried before it is marked failed, 5 by default
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubling on each retry. It defaults to 30 seconds
+	Backoff time.Duration
+	// ClaimTTL is how long a claimed message is reserved for the relay, after which another relay may take it over
+	// should this one have died. It must exceed the time a provider takes to answer, 2 minutes by default
+	ClaimTTL time.Duration
+}
+
+// Relay delivers the messages of an outbox. Several relays can run against the same outbox,
+// each message being claimed by one of them at a time. Delivery is at least once
+type Relay struct {
+	outbox Outbox
+	sms    SMSSender
+	push   PushSender
+	opts   RelayOptions
+	owner  string
+}
+
+// NewRelay creates a relay sending sms with sms and push notifications with push. Either can be nil when unused,
+// the messages of its channel then fail
+func NewRelay(o Outbox, sms SMSSender, push PushSender, opts RelayOptions) *Relay {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultPollInterval
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultMaxAttempts
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultBackoff
+	}
+	if opts.ClaimTTL <= 0 {
+		opts.ClaimTTL = defaultClaimTTL
+	}
+	return &Relay{outbox: o, sms: sms, push: push, opts: opts, owner: string(uuid.GenV4())}
+}
+
+// Run delivers messages until ctx is done
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		n, err := r.ProcessBatch(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Println("Unable to relay outbox messages with error: ", err)
+		}
+		if n == r.opts.BatchSize && err == nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.opts.PollInterval):
+		}
+	}
+}
+
+// ProcessBatch claims and delivers up to BatchSize due messages, returning how many were processed
+func (r *Relay) ProcessBatch(ctx context.Context) (int, error) {
+	processed := 0
+	for processed < r.opts.BatchSize {
+		m, err := r.claim(ctx)
+		if mongo.IsNotFoundError(err) {
+			return processed, nil
+		}
+		if err != nil {
+			return processed, err
+		}
+		if err := r.deliver(ctx, m); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+// claim reserves the next due message, or a message whose claim expired
+func (r *Relay) claim(ctx context.Context) (Message, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"$or": bson.A{
+			bson.M{"status": StatusPending, "nextAttemptAt": bson.M{"$lte": now}},
+			bson.M{"status": StatusProcessing, "claimedUntil": bson.M{"$lt": now}},
+		},
+	}
+	update := bson.M{
+		"$set": bson.M{"status": StatusProcessing, "claimedBy": r.owner, "claimedUntil": now.Add(r.opts.ClaimTTL)},
+		"$inc": bson.M{"attempts": 1},
+	}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).
+		SetReturnDocument(options.After)
+
+	var m Message
+	err := r.outbox.collection().FindOneAndUpdate(ctx, filter, update, opts).Decode(&m)
+	return m, err
+}
+
+// deliver sends a claimed message and records the outcome
+func (r *Relay) deliver(ctx context.Context, m Message) error {
+	providerID, sendErr := r.send(m)
+
+	now := time.Now().UTC()
+	set := bson.M{}
+	switch {
+	case sendErr == nil:
+		set["status"] = StatusSent
+		set["sentAt"] = now
+		set["providerId"] = providerID
+		set["lastError"] = ""
+	case m.Attempts >= r.opts.MaxAttempts:
+		log.Printf("Giving up %s message %s after %d attempts with error: %v", m.Channel, m.ID, m.Attempts, sendErr)
+		set["status"] = StatusFailed
+		set["lastError"] = sendErr.Error()
+	default:
+		set["status"] = StatusPending
+		set["lastError"] = sendErr.Error()
+		set["nextAttemptAt"] = now.Add(r.backoff(m.Attempts))
+	}
+
+	_, err := r.outbox.collection().UpdateOne(ctx, bson.M{"id": m.ID, "claimedBy": r.owner}, bson.M{"$set": set, "$unset": bson.M{"claimedUntil": ""}})
+	if err != nil {
+		return errors.Wrapf(err, "unable to record outcome of message %s", m.ID)
+	}
+	return nil
+}
+
+// backoff returns the wait before retrying a message tried attempts times
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.opts.Backoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// send dispatches a message to its provider, returning the id the provider gave it
+func (r *Relay) send(m Message) (string, error) {
+	switch m.Channel {
+	case ChannelSMS:
+		if r.sms == nil {
+			return "", errors.New("no sms sender is configured")
+		}
+		resp, err := r.sms.SendSMS(m.To, m.Body)
+		if err != nil {
+			return "", err
+		}
+		return resp.Sid, nil
+	case ChannelPush:
+		if r.push == nil {
+			return "", errors.New("no push sender is configured")
+		}
+		resp, err := r.push.NotifyDevice(m.To, m.Data, m.Title)
+		if err != nil {
+			return "", err
+		}
+		if resp.Failure > 0 {
+			for _, res := range resp.Results {
+				if res.Error != "" {
+					return "", fmt.Errorf("push notification was rejected: %s", res.Error)
+				}
+			}
+			return "", errors.New("push notification was rejected")
+		}
+		return fmt.Sprint(resp.MulticastID), nil
+	}
+	return "", fmt.Errorf("unknown channel %q", m.Channel)
+}
@@ -0,0 +1,70 @@
+package testutils
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/babyfaceEasy/commons/mongo"
+	"github.com/babyfaceEasy/commons/uuid"
+)
+
+const (
+	maxTestDBNameLength = 40
+	dropTestDBTimeout   = 10 * time.Second
+)
+
+var invalidDBNameChars = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// NewTestDB creates a uniquely named database on the local mongo for the test, dropped once the test completes
+func NewTestDB(t *testing.T) mongo.DBProviderFunc {
+	t.Helper()
+
+	provideDB, closeMongo, err := mongo.NewLocalConfig(testDBName(t)).ToProvider()
+	if err != nil {
+		t.Fatalf("Unable to connect to the local mongo: %v", err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), dropTestDBTimeout)
+		defer cancel()
+		if err := provideDB().Drop(ctx); err != nil {
+			t.Errorf("Unable to drop test database %s: %v", provideDB().Name(), err)
+		}
+		closeMongo()
+	})
+	return provideDB
+}
+
+// SeedCollection inserts the documents of a JSON fixture file, holding an array of objects, into a collection
+// JSON numbers are inserted as doubles
+func SeedCollection(t *testing.T, provideDB mongo.DBProviderFunc, collection, fixturePath string) {
+	t.Helper()
+
+	docs := []map[string]interface{}{}
+	FileToStruct(fixturePath, &docs)
+	if len(docs) == 0 {
+		t.Fatalf("Fixture %s holds no documents", fixturePath)
+	}
+
+	batch := make([]interface{}, 0, len(docs))
+	for _, d := range docs {
+		batch = append(batch, d)
+	}
+	c := mongo.NewCollection(provideDB, collection)
+	if _, err := c.InsertBatch(batch); err != nil {
+		t.Fatalf("Unable to seed collection %s from %s: %v", collection, fixturePath, err)
+	}
+}
+
+// testDBName builds a valid database name from the test name, unique across runs
+func testDBName(t *testing.T) string {
+	name := strings.Trim(invalidDBNameChars.ReplaceAllString(t.Name(), "_"), "_")
+	if len(name) > maxTestDBNameLength {
+		name = name[:maxTestDBNameLength]
+	}
+	suffix := strings.ReplaceAll(string(uuid.GenV4()), "-", "")[:8]
+	return "test_" + name + "_" + suffix
+}